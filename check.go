@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"for-sale-report/config"
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/logging"
+	"for-sale-report/metrics"
+	"for-sale-report/mls"
+	"for-sale-report/report"
+	"for-sale-report/review"
+	"for-sale-report/tracing"
+)
+
+// soldChecker is the part of mls.Session used to check people
+type soldChecker interface {
+	Lookup(ctx context.Context, addr string) (*mls.Match, error)
+	Close()
+}
+
+// loginMLS starts an MLS session, reusing the one saved at sessionPath when it is still valid;
+// replaced by a fake MLS in tests
+var loginMLS = func(ctx context.Context, config mls.Config, cache *mls.Cache, sessionPath string) (soldChecker, error) {
+	session, err := mls.Resume(ctx, config, cache, sessionPath)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// tenantLocks keeps runs and re-checks of the same tenant from overlapping, since they share
+// its MLS session, cache and pending writes. A nil *tenantLocks locks nothing.
+type tenantLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newTenantLocks() *tenantLocks {
+	return &tenantLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock waits until no other run or re-check of tenant holds the lock, and returns the unlock function
+func (l *tenantLocks) lock(tenant string) (unlock func()) {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	lock, ok := l.locks[tenant]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[tenant] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// tenantRun is everything needed to check people for one tenant during one run
+type tenantRun struct {
+	tenant      *config.Tenant
+	runID       string
+	client      *fub.Client
+	session     soldChecker
+	cache       *mls.Cache // nil when disabled
+	sessionPath string     // Where the MLS session is saved between runs
+	journal     *fub.Journal
+	writes      *fub.WriteQueue // Tag mode only
+	pending     *review.List    // Review mode only
+	record      *history.Run
+	metrics     *metrics.Metrics
+
+	artifactsDir string           // Where failed lookups are saved, empty unless debug artifacts are enabled
+	failures     []report.Failure // Failed lookups to list in the report, only with debug artifacts
+
+	memberships map[int]*history.Membership // From past runs, for the stage and smart list references
+	lastRun     time.Time                   // Start of the last complete run, for the last run reference
+}
+
+// newTenantRun connects to FUB and opens the state the tenant's mode needs, recording the run in record.
+// The MLS is logged in to separately, once the run knows it has people to check.
+func newTenantRun(ctx context.Context, cfg *config.Config, tenant *config.Tenant, runID string, m *metrics.Metrics, record *history.Run) (*tenantRun, error) {
+	fubConfig := tenant.FUB
+	fubConfig.HTTPClient = m.InstrumentFUB(tenant.Name, fubConfig.HTTPClient)
+	client, err := fub.New(fubConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := mls.OpenCache(cfg.MLSCachePath(tenant), tenant.MLS.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tenantRun{
+		tenant:      tenant,
+		runID:       runID,
+		client:      client,
+		cache:       cache,
+		record:      record,
+		metrics:     m,
+		sessionPath: cfg.MLSSessionPath(tenant),
+	}
+	if cfg.Debug.Artifacts {
+		tr.artifactsDir = cfg.ArtifactsDir(tenant, runID)
+	}
+
+	switch tenant.Since.Reference {
+	case config.SINCE_STAGE, config.SINCE_SMART_LIST, config.SINCE_LAST_RUN:
+		runs, err := history.Runs(cfg.RunsDir(tenant))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run history: %w", err)
+		}
+		tr.memberships = history.Memberships(runs)
+		if last := history.LastComplete(runs); last != nil {
+			tr.lastRun = last.Started
+		}
+	}
+
+	// Only tag mode writes to FUB during the run; review mode holds the changes until approved
+	switch tenant.Mode {
+	case config.MODE_TAG:
+		tr.journal, err = fub.OpenJournal(cfg.JournalDir(tenant), runID)
+		if err != nil {
+			return nil, err
+		}
+		tr.writes, err = fub.NewWriteQueue(client, tenant.FUB.Writes, filepath.Join(cfg.TenantDir(tenant), "pending_writes.json"), tr.journal)
+		if err != nil {
+			tr.close()
+			return nil, err
+		}
+	case config.MODE_REVIEW:
+		tr.pending, err = review.Open(cfg.ReviewPath(tenant))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.ResolveStages(ctx); err != nil {
+		tr.close()
+		return nil, err
+	}
+	return tr, nil
+}
+
+func (tr *tenantRun) login(ctx context.Context) error {
+	session, err := loginMLS(ctx, tr.tenant.MLS, tr.cache, tr.sessionPath)
+	if err != nil {
+		tr.saveArtifacts(ctx, "login", err)
+		return err
+	}
+	tr.session = session
+	return nil
+}
+
+// saveArtifacts writes the page carried by a failed lookup or login to the run's artifacts directory
+// as name.html (or name.txt when the page isn't HTML), and its screenshot, which only logins have, as name.png.
+// It returns the files written, none when debug artifacts are disabled or err has no page.
+func (tr *tenantRun) saveArtifacts(ctx context.Context, name string, err error) []string {
+	var pageErr *mls.PageError
+	if tr.artifactsDir == "" || !errors.As(err, &pageErr) {
+		return nil
+	}
+	if err := os.MkdirAll(tr.artifactsDir, 0o700); err != nil {
+		slog.WarnContext(ctx, "Failed to save debug artifacts", "error", err)
+		return nil
+	}
+
+	ext := ".txt"
+	if strings.HasPrefix(http.DetectContentType(pageErr.Page), "text/html") {
+		ext = ".html"
+	}
+	artifacts := map[string][]byte{name + ext: pageErr.Page, name + ".png": pageErr.Screenshot}
+
+	var files []string
+	for _, file := range []string{name + ext, name + ".png"} {
+		if len(artifacts[file]) == 0 {
+			continue
+		}
+		path := filepath.Join(tr.artifactsDir, file)
+		if err := os.WriteFile(path, artifacts[file], 0o600); err != nil {
+			slog.WarnContext(ctx, "Failed to save debug artifacts", "error", err)
+			continue
+		}
+		files = append(files, path)
+	}
+	if len(files) > 0 {
+		slog.InfoContext(ctx, "Saved debug artifacts", "url", pageErr.URL, "files", files)
+	}
+	return files
+}
+
+// finishRecord saves the run history with the error the run ended with, so runs that fail during setup are listed too
+func finishRecord(ctx context.Context, record *history.Run, err *error) {
+	if saveErr := record.Finish(*err); saveErr != nil {
+		slog.WarnContext(ctx, "Failed to save run history", "error", saveErr)
+	}
+}
+
+func (tr *tenantRun) close() {
+	if tr.session != nil {
+		tr.session.Close()
+	}
+	if err := tr.cache.Save(); err != nil {
+		slog.Warn("Failed to save MLS cache", logging.KEY_TENANT, tr.tenant.Name, "error", err)
+	}
+	if tr.journal != nil {
+		tr.journal.Close()
+	}
+}
+
+// since returns the date a sale must be after for person to have sold, as configured for the tenant
+func (tr *tenantRun) since(person fub.ListedPerson) time.Time {
+	sinceConfig := tr.tenant.Since
+	reference := person.CreatedAt // Also used when the chosen date isn't known
+
+	switch sinceConfig.Reference {
+	case config.SINCE_LAST_ACTIVITY:
+		if !person.LastActivity.IsZero() {
+			reference = person.LastActivity
+		}
+	case config.SINCE_STAGE:
+		// Someone seen before in another stage has only just entered this one
+		if m := tr.memberships[person.ID]; m != nil {
+			reference = tr.record.Started
+			if m.Stage == person.Stage {
+				reference = m.StageSince
+			}
+		}
+	case config.SINCE_SMART_LIST:
+		// The earliest of their lists; any they weren't in before were only just entered.
+		// Re-checks don't fetch smart lists, so they use every list the person was last seen in.
+		if m := tr.memberships[person.ID]; m != nil {
+			reference = tr.record.Started
+			for listID, since := range m.SmartLists {
+				listed := len(person.SmartLists) == 0 || slices.ContainsFunc(person.SmartLists, func(list fub.SmartList) bool { return list.ID == listID })
+				if listed && since.Before(reference) {
+					reference = since
+				}
+			}
+		}
+	case config.SINCE_LOOKBACK:
+		reference = tr.record.Started.Add(-sinceConfig.Lookback)
+	case config.SINCE_LAST_RUN:
+		if !tr.lastRun.IsZero() {
+			reference = tr.lastRun
+		}
+	}
+	return reference.AddDate(0, 0, -sinceConfig.GraceDays)
+}
+
+// check looks up one person and, when they have sold, tags them or holds them for review
+// depending on the mode. Lookup failures are recorded and logged; only local failures are returned.
+func (tr *tenantRun) check(ctx context.Context, person fub.ListedPerson) (hasSold bool, err error) {
+	ctx = logging.With(ctx, logging.KEY_PHASE, logging.PHASE_CHECK, logging.KEY_PERSON_ID, person.ID)
+	ctx, span := tracer.Start(ctx, "check", trace.WithAttributes(attribute.Int(logging.KEY_PERSON_ID, person.ID)))
+	start := time.Now()
+	since := tr.since(person)
+	result := history.Check{
+		PersonID:   person.ID,
+		Name:       person.Name,
+		Stage:      person.Stage,
+		SmartLists: person.SmartLists,
+		Since:      since,
+		Result:     history.RESULT_SKIPPED,
+	}
+	defer func() {
+		result.Duration = time.Since(start)
+		tr.record.AddCheck(result)
+		span.SetAttributes(attribute.String("result", result.Result))
+		tracing.End(span, &err)
+	}()
+
+	// Skip invalid people
+	if len(person.Addresses) == 0 {
+		slog.WarnContext(ctx, "Invalid person - no addresses")
+		result.Error = "No addresses"
+		return false, nil
+	}
+
+	// Skip excluded stages
+	if tr.client.PersonIsExcluded(&person.Person) {
+		result.Error = fmt.Sprintf("Stage %q is excluded", person.Stage)
+		slog.DebugContext(ctx, "Skipping excluded stage", "stage", person.Stage)
+		return false, nil
+	}
+
+	result.Address = person.Addresses[0].ToString()
+	match, err := tr.session.Lookup(ctx, result.Address)
+	if err != nil {
+		tr.metrics.Lookup(tr.tenant.Name, false, err)
+		slog.WarnContext(ctx, "MLS lookup failed", "address", result.Address, "error", err)
+		result.Result, result.Error = history.RESULT_ERROR, err.Error()
+		if tr.artifactsDir != "" {
+			tr.failures = append(tr.failures, report.Failure{
+				PersonID: person.ID,
+				Name:     person.Name,
+				Address:  result.Address,
+				Error:    err.Error(),
+				Files:    tr.saveArtifacts(ctx, strconv.Itoa(person.ID), err),
+			})
+		}
+		return false, nil
+	}
+	result.Match = match
+	ctx = logging.With(ctx, logging.KEY_MLS_ID, match.MlsID)
+
+	hasSold = match.SoldAt.After(since)
+	tr.metrics.Lookup(tr.tenant.Name, hasSold, nil)
+	if !hasSold {
+		result.Result = history.RESULT_NOT_SOLD
+		slog.DebugContext(ctx, "Not sold", "sold_at", match.SoldAt, "since", since)
+		return false, nil
+	}
+	result.Result = history.RESULT_SOLD
+
+	update := fub.Update{PersonID: person.ID, AddTags: []string{fub.SOLD_TAG}}
+	if tr.writes != nil {
+		if err := tr.writes.Enqueue(update); err != nil {
+			return false, err
+		}
+	}
+	if tr.pending != nil {
+		added, err := tr.pending.Add(review.Item{Person: person, Update: update, Match: match, RunID: tr.runID})
+		if err != nil {
+			return false, err
+		}
+		if !added {
+			result.Error = "Rejected in review for this sale"
+			slog.InfoContext(ctx, "Has sold but was rejected in review for this sale", "sold_at", match.SoldAt)
+			return false, nil
+		}
+	}
+	slog.InfoContext(ctx, "Has sold", "sold_at", match.SoldAt, "since", since, "smart_lists", person.SmartListNames())
+	return true, nil
+}
+
+// finish sends any queued updates and returns the sold people to report,
+// leaving out those whose update failed or is kept for the next run, with the report's introduction
+func (tr *tenantRun) finish(ctx context.Context, soldPeople []fub.ListedPerson) ([]fub.ListedPerson, string, error) {
+	ctx = logging.With(ctx, logging.KEY_PHASE, logging.PHASE_WRITE)
+
+	switch tr.tenant.Mode {
+	case config.MODE_TAG:
+		// Send every update, including any left over from an interrupted run
+		slog.InfoContext(ctx, "Sending pending updates", "count", tr.writes.Len())
+		failed, err := tr.writes.Flush(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		if kept := tr.writes.Len(); kept > 0 {
+			slog.WarnContext(ctx, "Updates kept for the next run", "count", kept)
+		}
+
+		updatedPeople := make([]fub.ListedPerson, 0, len(soldPeople))
+		for _, person := range soldPeople {
+			if _, ok := failed[person.ID]; !ok {
+				updatedPeople = append(updatedPeople, person)
+			}
+		}
+		return updatedPeople, report.INTRO_TAGGED, nil
+	case config.MODE_REVIEW:
+		slog.InfoContext(ctx, "People waiting for review", "count", len(tr.pending.Items()), "approve", "approve "+tr.tenant.Name+" all")
+		return soldPeople, report.INTRO_REVIEW, nil
+	default:
+		return soldPeople, report.INTRO_REPORTED, nil
+	}
+}
+
+// recheckPerson runs the check for a single person outside the daily run, as a run of its own
+// so it appears in the history and can be rolled back. No report is sent.
+func recheckPerson(ctx context.Context, cfg *config.Config, tenant *config.Tenant, personID int, runID string, m *metrics.Metrics, locks *tenantLocks) (err error) {
+	defer locks.lock(tenant.Name)()
+
+	ctx, span := tracer.Start(ctx, "recheck", trace.WithAttributes(
+		attribute.String(logging.KEY_RUN_ID, runID),
+		attribute.String(logging.KEY_TENANT, tenant.Name),
+		attribute.Int(logging.KEY_PERSON_ID, personID),
+	))
+	defer tracing.End(span, &err)
+
+	record := history.Start(cfg.RunsDir(tenant), tenant.Name, runID, tenant.Mode)
+	defer finishRecord(ctx, record, &err)
+
+	tr, err := newTenantRun(ctx, cfg, tenant, runID, m, record)
+	if err != nil {
+		return err
+	}
+	defer tr.close()
+
+	setupCtx := logging.With(ctx, logging.KEY_PHASE, logging.PHASE_SETUP, logging.KEY_PERSON_ID, personID)
+	person, err := tr.client.GetPerson(setupCtx, personID)
+	if err != nil {
+		return err
+	}
+	if err := tr.login(setupCtx); err != nil {
+		return err
+	}
+
+	hasSold, err := tr.check(ctx, fub.ListedPerson{Person: *person})
+	if err != nil {
+		return err
+	}
+	if !hasSold {
+		return nil
+	}
+	_, _, err = tr.finish(ctx, nil)
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"for-sale-report/config"
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/metrics"
+)
+
+func TestTenantLocks(t *testing.T) {
+	locks := newTenantLocks()
+	unlock := locks.lock("north")
+
+	// Another tenant isn't held up
+	locks.lock("south")()
+
+	acquired := make(chan struct{})
+	go func() {
+		defer locks.lock("north")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second lock of the same tenant didn't wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("lock wasn't released")
+	}
+
+	// nil locks nothing
+	var none *tenantLocks
+	none.lock("north")()
+	none.lock("north")()
+}
+
+func TestSince(t *testing.T) {
+	now := time.Date(2025, 6, 1, 6, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return now.AddDate(0, 0, n) }
+	sellers, expired := fub.SmartList{ID: 3, Name: "Sellers"}, fub.SmartList{ID: 5, Name: "Expired"}
+
+	person := fub.ListedPerson{
+		Person:     fub.Person{ID: 1, CreatedAt: day(-400), LastActivity: day(-20), Stage: "Lead"},
+		SmartLists: []fub.SmartList{sellers, expired},
+	}
+	unseen := fub.ListedPerson{Person: fub.Person{ID: 2, CreatedAt: day(-400), Stage: "Lead"}, SmartLists: []fub.SmartList{sellers}}
+	inactive := fub.ListedPerson{Person: fub.Person{ID: 3, CreatedAt: day(-400), Stage: "Lead"}}
+	moved := fub.ListedPerson{Person: fub.Person{ID: 4, CreatedAt: day(-400), Stage: "Past Client"}, SmartLists: []fub.SmartList{sellers}}
+
+	memberships := map[int]*history.Membership{
+		1: {Stage: "Lead", StageSince: day(-90), SmartLists: map[int]time.Time{3: day(-60), 5: day(-30)}},
+		4: {Stage: "Lead", StageSince: day(-90), SmartLists: map[int]time.Time{5: day(-30)}},
+	}
+
+	tests := []struct {
+		name    string
+		since   config.SinceConfig
+		person  fub.ListedPerson
+		lastRun time.Time
+		want    time.Time
+	}{
+		{"default", config.SinceConfig{}, person, time.Time{}, day(-400)},
+		{"created", config.SinceConfig{Reference: config.SINCE_CREATED}, person, time.Time{}, day(-400)},
+		{"created with grace", config.SinceConfig{Reference: config.SINCE_CREATED, GraceDays: 3}, person, time.Time{}, day(-403)},
+		{"created with negative grace", config.SinceConfig{Reference: config.SINCE_CREATED, GraceDays: -3}, person, time.Time{}, day(-397)},
+		{"last activity", config.SinceConfig{Reference: config.SINCE_LAST_ACTIVITY}, person, time.Time{}, day(-20)},
+		{"no activity", config.SinceConfig{Reference: config.SINCE_LAST_ACTIVITY}, inactive, time.Time{}, day(-400)},
+		{"stage", config.SinceConfig{Reference: config.SINCE_STAGE, GraceDays: 1}, person, time.Time{}, day(-91)},
+		{"stage never seen", config.SinceConfig{Reference: config.SINCE_STAGE}, unseen, time.Time{}, day(-400)},
+		{"stage just changed", config.SinceConfig{Reference: config.SINCE_STAGE}, moved, time.Time{}, now},
+		{"smart list", config.SinceConfig{Reference: config.SINCE_SMART_LIST}, person, time.Time{}, day(-60)},
+		{"smart list never seen", config.SinceConfig{Reference: config.SINCE_SMART_LIST}, unseen, time.Time{}, day(-400)},
+		{"smart list just entered", config.SinceConfig{Reference: config.SINCE_SMART_LIST}, moved, time.Time{}, now},
+		{"smart list on re-check", config.SinceConfig{Reference: config.SINCE_SMART_LIST}, fub.ListedPerson{Person: person.Person}, time.Time{}, day(-60)},
+		{"lookback", config.SinceConfig{Reference: config.SINCE_LOOKBACK, Lookback: 30 * 24 * time.Hour}, person, time.Time{}, day(-30)},
+		{"last run", config.SinceConfig{Reference: config.SINCE_LAST_RUN}, person, day(-1), day(-1)},
+		{"no last run", config.SinceConfig{Reference: config.SINCE_LAST_RUN}, person, time.Time{}, day(-400)},
+	}
+	for _, tt := range tests {
+		record := history.Start(t.TempDir(), "north", "run", config.MODE_TAG)
+		record.Started = now
+		tr := &tenantRun{
+			tenant:      &config.Tenant{Name: "north", Since: tt.since},
+			record:      record,
+			memberships: memberships,
+			lastRun:     tt.lastRun,
+		}
+		if got := tr.since(tt.person); !got.Equal(tt.want) {
+			t.Errorf("%s: since = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSinceFromLastRun(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{sold: map[string]time.Time{"1 Main St, Springfield": time.Now().AddDate(0, 0, -1)}})
+	env := newTestEnv(t, config.MODE_REPORT, map[int][]fub.Person{7: {newPerson(1, "Lead", "1 Main St", created)}})
+	env.tenant().Since = config.SinceConfig{Reference: config.SINCE_LAST_RUN}
+
+	// Without a past run the created date is used, so yesterday's sale counts
+	if err := run(context.Background(), env.cfg, "first", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The second run only counts sales since the first one
+	if err := run(context.Background(), env.cfg, "second", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	runsDir := env.cfg.RunsDir(env.tenant())
+	first, err := history.Load(runsDir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := history.Load(runsDir, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := first.Checks[0]; got.Result != history.RESULT_SOLD || !got.Since.Equal(created) {
+		t.Errorf("first run = %s since %v, want sold since %v", got.Result, got.Since, created)
+	}
+	if got := second.Checks[0]; got.Result != history.RESULT_NOT_SOLD || !got.Since.Equal(first.Started) {
+		t.Errorf("second run = %s since %v, want not sold since %v", got.Result, got.Since, first.Started)
+	}
+}
+
+func TestDebugArtifacts(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{
+		sold:  map[string]time.Time{"1 Main St, Springfield": created.AddDate(0, 1, 0)},
+		pages: map[string]string{"2 Main St, Springfield": "<html><body>Please log in</body></html>"},
+	})
+	env := newTestEnv(t, config.MODE_REPORT, map[int][]fub.Person{7: {
+		newPerson(1, "Lead", "1 Main St", created),
+		newPerson(2, "Lead", "2 Main St", created),
+		newPerson(3, "Lead", "3 Main St", created),
+	}})
+	env.cfg.Debug.Artifacts = true
+
+	if err := run(context.Background(), env.cfg, "debug-run", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Person 2's page is saved; person 3 has no page to save
+	dir := env.cfg.ArtifactsDir(env.tenant(), "debug-run")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if fmt.Sprint(names) != "[2.html]" {
+		t.Errorf("artifacts = %v, want [2.html]", names)
+	}
+	if page, _ := os.ReadFile(filepath.Join(dir, "2.html")); !strings.Contains(string(page), "Please log in") {
+		t.Errorf("2.html = %q", page)
+	}
+
+	// Both failures are listed in the report, with the files
+	msg := env.smtp.Messages()
+	if len(msg) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msg))
+	}
+	for _, want := range []string{"Failed Lookups", "Invalid JSON wrapper", "No results found", filepath.Join(dir, "2.html")} {
+		if !strings.Contains(msg[0].Data, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+}
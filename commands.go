@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"for-sale-report/config"
+	"for-sale-report/dashboard"
+	"for-sale-report/fub"
+	"for-sale-report/logging"
+	"for-sale-report/metrics"
+	"for-sale-report/review"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", flag.CommandLine.Name())
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  run              check every tenant's smart lists and email reports (default)")
+	fmt.Fprintln(out, "  list-smartlists  print the smart lists available to each tenant's API key")
+	fmt.Fprintln(out, "  rollback <run>   undo the tags, stages, notes and tasks written by a run")
+	fmt.Fprintln(out, "  daemon           run every [daemon] interval, serving the dashboard and /metrics")
+	fmt.Fprintln(out, "  dashboard        serve run history and lookups on the [dashboard] listen address")
+	fmt.Fprintln(out, "  review           print the people waiting for approval in review mode")
+	fmt.Fprintln(out, "  approve <tenant> <person-id>... | all")
+	fmt.Fprintln(out, "                   tag the given people in FUB and remove them from review")
+	fmt.Fprintln(out, "  reject <tenant> <person-id>... | all")
+	fmt.Fprintln(out, "                   remove the given people from review without changing FUB")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// listSmartLists prints the ID and name of every smart list each tenant can see
+func listSmartLists(ctx context.Context, cfg *config.Config, out io.Writer) error {
+	for i, tenant := range cfg.Tenants {
+		client, err := fub.New(tenant.FUB)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tenant.Name, err)
+		}
+
+		smartLists, err := client.SmartLists(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tenant.Name, err)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%s:\n", tenant.Name)
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  ID\tNAME")
+		for _, smartList := range smartLists {
+			fmt.Fprintf(w, "  %d\t%s\n", smartList.ID, smartList.Name)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores every person changed by runID, for each tenant that has a journal for it
+func rollback(ctx context.Context, cfg *config.Config, runID string) error {
+	found := false
+	var errs []error
+
+	for i := range cfg.Tenants {
+		tenant := &cfg.Tenants[i]
+
+		entries, err := fub.ReadJournal(fub.JournalPath(cfg.JournalDir(tenant), runID))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tenant.Name, err))
+			continue
+		}
+		found = true
+
+		client, err := fub.New(tenant.FUB)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tenant.Name, err))
+			continue
+		}
+
+		tenantCtx := logging.With(ctx, logging.KEY_TENANT, tenant.Name, logging.KEY_RUN_ID, runID, logging.KEY_PHASE, logging.PHASE_ROLLBACK)
+		slog.InfoContext(tenantCtx, "Rolling back run", "changes", len(entries))
+		if err := client.Rollback(tenantCtx, entries); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tenant.Name, err))
+		}
+	}
+
+	if !found && len(errs) == 0 {
+		return fmt.Errorf("no journal found for run %q", runID)
+	}
+	return errors.Join(errs...)
+}
+
+// listReview prints every person waiting for approval, per tenant
+func listReview(cfg *config.Config, out io.Writer) error {
+	for i := range cfg.Tenants {
+		tenant := &cfg.Tenants[i]
+		pending, err := review.Open(cfg.ReviewPath(tenant))
+		if err != nil {
+			return fmt.Errorf("%s: %w", tenant.Name, err)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		items := pending.Items()
+		fmt.Fprintf(out, "%s: %d waiting for review\n", tenant.Name, len(items))
+		if len(items) == 0 {
+			continue
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  ID\tNAME\tADDRESS\tFLAGGED\tRUN")
+		for _, item := range items {
+			address := ""
+			if len(item.Person.Addresses) > 0 {
+				address = item.Person.Addresses[0].ToString()
+			}
+			fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%s\n", item.Person.ID, item.Person.Name, address, item.FlaggedAt.Format(time.DateOnly), item.RunID)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseReviewArgs reads "<tenant> <person-id>..." or "<tenant> all"; no IDs means everyone
+func parseReviewArgs(cfg *config.Config, args []string) (*config.Tenant, []int, error) {
+	if len(args) < 2 {
+		return nil, nil, fmt.Errorf("needs a tenant and person IDs or \"all\"")
+	}
+
+	var tenant *config.Tenant
+	for i := range cfg.Tenants {
+		if cfg.Tenants[i].Name == args[0] {
+			tenant = &cfg.Tenants[i]
+		}
+	}
+	if tenant == nil {
+		return nil, nil, fmt.Errorf("unknown tenant %q", args[0])
+	}
+
+	if len(args) == 2 && strings.EqualFold(args[1], "all") {
+		return tenant, nil, nil
+	}
+	ids := make([]int, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, nil, fmt.Errorf("%q is not a person ID", arg)
+		}
+		ids = append(ids, id)
+	}
+	return tenant, ids, nil
+}
+
+// approve sends the held changes for the given people (everyone when ids is empty) to FUB,
+// journaled under runID. People whose update fails stay on the list.
+func approve(ctx context.Context, cfg *config.Config, tenant *config.Tenant, ids []int, runID string) error {
+	ctx = logging.With(ctx, logging.KEY_TENANT, tenant.Name, logging.KEY_RUN_ID, runID, logging.KEY_PHASE, logging.PHASE_REVIEW)
+	pending, err := review.Open(cfg.ReviewPath(tenant))
+	if err != nil {
+		return err
+	}
+	items, missing, err := pending.Take(ids)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		slog.WarnContext(ctx, "Not waiting for review", "person_ids", missing)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	client, err := fub.New(tenant.FUB)
+	if err != nil {
+		return err
+	}
+	journal, err := fub.OpenJournal(cfg.JournalDir(tenant), runID)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	writes, err := fub.NewWriteQueue(client, tenant.FUB.Writes, filepath.Join(cfg.TenantDir(tenant), "pending_writes.json"), journal)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := writes.Enqueue(item.Update); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "Approving people", "count", len(items), "undo", "rollback "+runID)
+	failed, err := writes.Flush(ctx)
+
+	// Anyone whose update was dropped goes back on the list to be approved again;
+	// updates kept after retryable errors are sent by the next approval instead
+	dropped := 0
+	for _, item := range items {
+		if failErr, ok := failed[item.Person.ID]; ok && !fub.IsRetryable(failErr) {
+			if _, err := pending.Add(item); err != nil {
+				return err
+			}
+			dropped++
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %d of %d approved updates failed; %d are waiting for review again and %d will be sent by the next approval",
+			tenant.Name, len(failed), len(items), dropped, len(failed)-dropped)
+	}
+	return nil
+}
+
+// reject drops the given people (everyone when ids is empty) from review without changing FUB,
+// remembering them so they aren't flagged again until their MLS match changes
+func reject(cfg *config.Config, tenant *config.Tenant, ids []int) error {
+	pending, err := review.Open(cfg.ReviewPath(tenant))
+	if err != nil {
+		return err
+	}
+	items, missing, err := pending.Reject(ids)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		slog.Warn("Not waiting for review", logging.KEY_TENANT, tenant.Name, logging.KEY_PHASE, logging.PHASE_REVIEW, "person_ids", missing)
+	}
+	slog.Info("Rejected people", logging.KEY_TENANT, tenant.Name, logging.KEY_PHASE, logging.PHASE_REVIEW, "count", len(items))
+	return nil
+}
+
+// newDashboard serves every tenant's run history. Re-checks take the tenant's lock from locks,
+// so they wait for runs and other re-checks of the same tenant. They are disabled when locks is nil,
+// as in the standalone dashboard, whose locks can't see runs started by the timer in another process.
+func newDashboard(cfg *config.Config, m *metrics.Metrics, locks *tenantLocks) *dashboard.Server {
+	tenants := make([]dashboard.Tenant, 0, len(cfg.Tenants))
+	for i := range cfg.Tenants {
+		tenants = append(tenants, dashboard.Tenant{Name: cfg.Tenants[i].Name, RunsDir: cfg.RunsDir(&cfg.Tenants[i])})
+	}
+	if locks == nil {
+		return dashboard.New(tenants, nil)
+	}
+
+	recheck := func(ctx context.Context, name string, personID int) (string, error) {
+		for i := range cfg.Tenants {
+			if tenant := &cfg.Tenants[i]; tenant.Name == name {
+				runID := newRunID()
+				ctx = logging.With(ctx, logging.KEY_RUN_ID, runID, logging.KEY_TENANT, name)
+				slog.InfoContext(ctx, "Re-checking person", logging.KEY_PERSON_ID, personID)
+				return runID, recheckPerson(ctx, cfg, tenant, personID, runID, m, locks)
+			}
+		}
+		return "", fmt.Errorf("unknown tenant %q", name)
+	}
+
+	return dashboard.New(tenants, recheck)
+}
+
+// daemon runs every tenant now and then every cfg.Daemon.Interval until ctx is done,
+// serving the dashboard and Prometheus metrics in between
+func daemon(ctx context.Context, cfg *config.Config) error {
+	m := metrics.New()
+	m.RegisterProcess()
+
+	// Scheduled runs and dashboard re-checks never work on the same tenant at once
+	locks := newTenantLocks()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	mux.Handle("/", newDashboard(cfg, m, locks))
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- dashboard.ListenAndServe(ctx, cfg.Dashboard.Listen, mux) }()
+
+	ticker := time.NewTicker(cfg.Daemon.Interval)
+	defer ticker.Stop()
+	for {
+		// A failed run is retried at the next interval rather than stopping the daemon
+		if err := run(ctx, cfg, newRunID(), m, locks); err != nil {
+			slog.ErrorContext(ctx, "Run failed", "error", err)
+		}
+		slog.InfoContext(ctx, "Waiting for next run", "interval", cfg.Daemon.Interval)
+
+		select {
+		case <-ctx.Done():
+			return <-serveErr
+		case err := <-serveErr:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"for-sale-report/config"
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/internal/fakefub"
+	"for-sale-report/metrics"
+	"for-sale-report/review"
+)
+
+func TestListSmartLists(t *testing.T) {
+	server := fakefub.New("key", map[int][]fub.Person{3: nil, 12: nil})
+	server.SmartListNames = map[int]string{12: "Past Sellers"}
+	defer server.Close()
+
+	cfg := &config.Config{Tenants: []config.Tenant{
+		{Name: "north", FUB: fub.Config{BaseURL: server.URL, APIKey: "key", SellerSmartlistIDs: []string{"3"}}},
+	}}
+
+	var out strings.Builder
+	if err := listSmartLists(context.Background(), cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "north:\n  ID  NAME\n  3   Smart List 3\n  12  Past Sellers\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{sold: map[string]time.Time{
+		"1 Main St, Springfield": created.AddDate(0, 3, 0),
+		"2 Main St, Springfield": created.AddDate(1, 0, 0),
+	}})
+	env := newTestEnv(t, config.MODE_TAG, map[int][]fub.Person{7: {
+		newPerson(1, "Lead", "1 Main St", created),
+		newPerson(2, "Lead", "2 Main St", created),
+	}})
+	if err := run(context.Background(), env.cfg, "tag-run", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling the run back removes the tags it added
+	if err := rollback(context.Background(), env.cfg, "tag-run"); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+	for _, id := range []int{1, 2} {
+		if tags := env.fub.Person(id).Tags; len(tags) != 0 {
+			t.Errorf("person %d still has tags %v after rollback", id, tags)
+		}
+	}
+	if err := rollback(context.Background(), env.cfg, "no-such-run"); err == nil {
+		t.Error("rollback of an unknown run should fail")
+	}
+}
+
+func TestReviewMode(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{sold: map[string]time.Time{
+		"1 Main St, Springfield": created.AddDate(0, 1, 0),
+		"2 Main St, Springfield": created.AddDate(0, 1, 0),
+		"3 Main St, Springfield": created.AddDate(0, -1, 0),
+	}})
+	env := newTestEnv(t, config.MODE_REVIEW, map[int][]fub.Person{7: {
+		newPerson(1, "Lead", "1 Main St", created),
+		newPerson(2, "Lead", "2 Main St", created),
+		newPerson(3, "Lead", "3 Main St", created),
+	}})
+	cfg, tenant := env.cfg, env.tenant()
+
+	// Nothing is written during the run; sold people wait for review
+	if err := run(context.Background(), cfg, "review-run", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.fub.Writes()) != 0 {
+		t.Errorf("review mode wrote to FUB: %v", env.fub.Writes())
+	}
+	if msg := env.smtp.Messages(); len(msg) != 1 || !strings.Contains(msg[0].Data, "waiting for approval") || !strings.Contains(msg[0].Data, "Person 2") {
+		t.Errorf("unexpected report: %v", msg)
+	}
+
+	var out strings.Builder
+	if err := listReview(cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"north: 2 waiting for review", "1 Main St, Springfield", "review-run"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("review list missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// Approving tags only the chosen person, under a run that can be rolled back
+	_, ids, err := parseReviewArgs(cfg, []string{"north", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := approve(context.Background(), cfg, tenant, ids, "approve-run"); err != nil {
+		t.Fatal(err)
+	}
+	if puts := env.fub.Puts(); len(puts) != 1 || puts[0].PersonID != 2 {
+		t.Errorf("puts = %v, want person 2", puts)
+	}
+	if err := rollback(context.Background(), cfg, "approve-run"); err != nil {
+		t.Errorf("approved changes can't be rolled back: %v", err)
+	}
+
+	// Rejecting the rest empties the list without writing anything
+	if err := reject(cfg, tenant, nil); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	listReview(cfg, &out)
+	if out.String() != "north: 0 waiting for review\n" {
+		t.Errorf("review list after reject:\n%s", out.String())
+	}
+	if len(env.fub.Puts()) != 2 {
+		t.Errorf("got %d PUTs, want the approval and its rollback", len(env.fub.Puts()))
+	}
+
+	// The next run doesn't flag the rejected person again for the same sale
+	if err := run(context.Background(), cfg, "review-run-2", metrics.New(), nil); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := review.Open(cfg.ReviewPath(tenant))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range pending.Items() {
+		if item.Person.ID == 1 {
+			t.Errorf("rejected person 1 is waiting for review again")
+		}
+	}
+
+	if _, _, err := parseReviewArgs(cfg, []string{"south", "all"}); err == nil {
+		t.Error("parseReviewArgs should reject unknown tenants")
+	}
+}
+
+func TestDashboardRecheck(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{sold: map[string]time.Time{"6 Main St, Springfield": created.AddDate(0, -3, 0)}})
+	env := newTestEnv(t, config.MODE_TAG, map[int][]fub.Person{7: {newPerson(6, "Lead", "6 Main St", created)}})
+
+	// The dashboard can re-check one person as a run of its own, even twice in the same second
+	dashboardServer := httptest.NewServer(newDashboard(env.cfg, metrics.New(), newTenantLocks()))
+	defer dashboardServer.Close()
+	for range 2 {
+		res, err := http.Post(dashboardServer.URL+"/people/north/6/recheck", "application/x-www-form-urlencoded", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "6 Main St, Springfield") || !strings.Contains(string(body), "not sold") {
+			t.Errorf("re-check returned %d:\n%s", res.StatusCode, body)
+		}
+	}
+	runs, err := history.Runs(env.cfg.RunsDir(env.tenant()))
+	if err != nil || len(runs) != 2 || runs[0].ID == runs[1].ID {
+		t.Errorf("re-checks weren't recorded as runs of their own: %v, %v", runs, err)
+	}
+
+	// The standalone dashboard can't see runs in other processes, so it doesn't re-check
+	standalone := httptest.NewServer(newDashboard(env.cfg, metrics.New(), nil))
+	defer standalone.Close()
+	res, err := http.Post(standalone.URL+"/people/north/6/recheck", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("standalone re-check returned %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,789 @@
+// Package config loads, migrates and validates the TOML configuration file
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"for-sale-report/dashboard"
+	"for-sale-report/fub"
+	"for-sale-report/internal/atomicfile"
+	"for-sale-report/logging"
+	"for-sale-report/metrics"
+	"for-sale-report/mls"
+	"for-sale-report/report"
+	"for-sale-report/tracing"
+)
+
+const CONFIG_VERSION = 2 // Bump and add an entry to configMigrations when the schema changes
+const DEFAULT_DATA_DIR = "data"
+const DEFAULT_DAEMON_INTERVAL = 24 * time.Hour
+
+// What a run does with the people it finds have sold
+const (
+	MODE_TAG    = "tag"    // Tag them in FUB and report them
+	MODE_REPORT = "report" // Only report them
+	MODE_REVIEW = "review" // Report them and hold the tags until approved
+)
+
+// What a sale must be after for a person to count as sold
+const (
+	SINCE_CREATED       = "created"       // The person was created in FUB
+	SINCE_LAST_ACTIVITY = "last_activity" // The person's last activity in FUB
+	SINCE_STAGE         = "stage"         // The person entered their stage, as seen by past runs
+	SINCE_SMART_LIST    = "smart_list"    // The person entered one of their smart lists, as seen by past runs
+	SINCE_LOOKBACK      = "lookback"      // A fixed window before the run
+	SINCE_LAST_RUN      = "last_run"      // The last run that completed
+)
+
+// Config represents the application configuration
+type Config struct {
+	Version   int              `toml:"version"`
+	DataDir   string           `toml:"data_dir"` // State kept between runs, one subdirectory per tenant
+	Mode      string           `toml:"mode"`     // Default for tenants without their own mode, MODE_TAG when empty
+	SMTP      report.Config    `toml:"smtp"`
+	Log       logging.Config   `toml:"log"`
+	Dashboard dashboard.Config `toml:"dashboard"`
+	Metrics   metrics.Config   `toml:"metrics"`
+	Tracing   tracing.Config   `toml:"tracing"`
+	Daemon    DaemonConfig     `toml:"daemon"`
+	Debug     DebugConfig      `toml:"debug"`
+	Tenants   []Tenant         `toml:"tenant"`
+}
+
+// DaemonConfig controls the daemon command, which runs on a schedule instead of from a timer
+type DaemonConfig struct {
+	Interval time.Duration `toml:"interval"` // Time between runs, DEFAULT_DAEMON_INTERVAL when 0
+}
+
+// DebugConfig controls what is kept to help diagnose failed lookups
+type DebugConfig struct {
+	Artifacts bool `toml:"artifacts"` // Save the response to each failed MLS lookup, and the page and a screenshot of a failed login
+}
+
+// TenantDir returns the directory holding a tenant's state between runs
+func (c *Config) TenantDir(tenant *Tenant) string {
+	return filepath.Join(c.DataDir, tenant.Name)
+}
+
+// JournalDir returns the directory holding a tenant's run journals, used for rollback
+func (c *Config) JournalDir(tenant *Tenant) string {
+	return filepath.Join(c.TenantDir(tenant), "journal")
+}
+
+// RunsDir returns the directory holding a tenant's run history, shown on the dashboard
+func (c *Config) RunsDir(tenant *Tenant) string {
+	return filepath.Join(c.TenantDir(tenant), "runs")
+}
+
+// MLSCachePath returns the file caching a tenant's MLS lookups between runs
+func (c *Config) MLSCachePath(tenant *Tenant) string {
+	return filepath.Join(c.TenantDir(tenant), "mls_cache.json")
+}
+
+// MLSSessionPath returns the file holding a tenant's encrypted MLS session between runs
+func (c *Config) MLSSessionPath(tenant *Tenant) string {
+	return filepath.Join(c.TenantDir(tenant), "mls_session.enc")
+}
+
+// ArtifactsDir returns the directory holding the debug artifacts of one of a tenant's runs
+func (c *Config) ArtifactsDir(tenant *Tenant, runID string) string {
+	return filepath.Join(c.TenantDir(tenant), "artifacts", runID)
+}
+
+// ReviewPath returns the file holding a tenant's people waiting for approval in review mode
+func (c *Config) ReviewPath(tenant *Tenant) string {
+	return filepath.Join(c.TenantDir(tenant), "pending_review.json")
+}
+
+// Tenant represents one team, processed in isolation with its own accounts and report
+type Tenant struct {
+	Name     string      `toml:"name"`
+	Mode     string      `toml:"mode"` // Overrides the top-level mode
+	ReportTo []string    `toml:"report_to"`
+	Since    SinceConfig `toml:"since"`
+	FUB      fub.Config  `toml:"fub"`
+	MLS      mls.Config  `toml:"mls"`
+}
+
+// SinceConfig chooses the date a sale must be after for a person to count as sold.
+// When that date isn't known, such as a person never seen by a past run, the created date is used.
+type SinceConfig struct {
+	Reference string        `toml:"reference"` // One of the SINCE_ values, SINCE_CREATED when empty
+	Lookback  time.Duration `toml:"lookback"`  // The window for SINCE_LOOKBACK
+
+	// Days before the reference that sales also count, e.g. for sales recorded late.
+	// Negative to only count sales at least that many days after it.
+	GraceDays int `toml:"grace_days"`
+}
+
+// getDefaultConfig returns a Config struct with default values
+func getDefaultConfig() Config {
+	headless := true
+
+	return Config{
+		Version: CONFIG_VERSION,
+		DataDir: DEFAULT_DATA_DIR,
+		Mode:    MODE_TAG, // "tag", "report" or "review"
+		SMTP: report.Config{
+			User: "",          // Required - will be empty in default config
+			Pass: "",          // Required - will be empty in default config
+			From: "",          // Required - will be empty in default config
+			Host: "127.0.0.1", // Default value
+			Port: "1025",      // Default value
+		},
+		Log: logging.Config{
+			Format: logging.DEFAULT_FORMAT, // "text" or "json"
+			Level:  logging.DEFAULT_LEVEL,  // "debug", "info", "warn" or "error"
+		},
+		Dashboard: dashboard.Config{
+			Listen: dashboard.DEFAULT_LISTEN,
+		},
+		Metrics: metrics.Config{
+			Textfile: "", // Optional - e.g. /var/lib/node_exporter/textfile_collector/for_sale_report.prom
+		},
+		Tracing: tracing.Config{
+			Exporter: tracing.EXPORTER_NONE, // Optional - "otlp" or "stdout"
+			Endpoint: "",                    // Optional - e.g. http://localhost:4318, uses OTEL_EXPORTER_OTLP_* when empty
+			File:     "",                    // Optional - file the stdout exporter writes to instead of stdout
+		},
+		Daemon: DaemonConfig{
+			Interval: DEFAULT_DAEMON_INTERVAL,
+		},
+		Debug: DebugConfig{
+			Artifacts: false, // Set to true to save what FlexMLS returned for failed lookups and logins
+		},
+		Tenants: []Tenant{
+			{
+				Name:     "default",  // Required - unique per tenant
+				Mode:     "",         // Optional - uses the top-level mode when empty
+				ReportTo: []string{}, // Required - will be empty in default config
+				Since: SinceConfig{
+					Reference: SINCE_CREATED, // "created", "last_activity", "stage", "smart_list", "lookback" or "last_run"
+					Lookback:  0,             // Required for "lookback" - e.g. 8760h for a year
+					GraceDays: 0,             // Optional - days before the reference that sales also count
+				},
+				FUB: fub.Config{
+					BaseURL:            fub.DEFAULT_BASE_URL,
+					APIKey:             "",         // Required - will be empty in default config
+					SellerSmartlistIDs: []string{}, // Required unless seller_smartlists is set
+					SellerSmartlists:   []string{}, // Smart list names, resolved at startup
+					ExcludedStages:     []string{}, // Optional
+					IncludedStages:     []string{}, // Optional - only process these stages
+					UnknownStages:      "warn",     // "warn" or "fail" on stages missing from FUB
+					Writes: fub.WriteConfig{
+						PerSecond:   fub.DEFAULT_WRITES_PER_SECOND,
+						MaxAttempts: fub.DEFAULT_MAX_ATTEMPTS,
+						RetryDelay:  fub.DEFAULT_RETRY_DELAY,
+					},
+				},
+				MLS: mls.Config{
+					User:       "", // Required - will be empty in default config
+					Pass:       "", // Required - will be empty in default config
+					TOTPSecret: "", // Optional - base32 secret when the account uses an authenticator app
+					TimeZone:   "", // Optional - the market's time zone, e.g. America/Chicago; UTC when empty
+					LoginURL:   mls.DEFAULT_LOGIN_URL,
+					SearchURL:  mls.DEFAULT_SEARCH_URL,
+					HistoryURL: mls.DEFAULT_HISTORY_URL,
+					Browser: mls.BrowserConfig{
+						Headless:    &headless,
+						ExecPath:    "",    // Optional - e.g. /usr/bin/chromium
+						Proxy:       "",    // Optional - e.g. http://proxy.example.com:3128
+						UserAgent:   "",    // Optional - overrides the browser's user agent
+						NoSandbox:   false, // Set to true when running as root, e.g. in a container
+						UserDataDir: "",    // Optional - persistent profile directory
+						RemoteURL:   "",    // Optional - e.g. ws://127.0.0.1:9222 to use a running Chrome
+					},
+					Cache: mls.CacheConfig{
+						Disabled:   false,
+						SearchTTL:  mls.DEFAULT_SEARCH_TTL,
+						HistoryTTL: mls.DEFAULT_HISTORY_TTL,
+					},
+					Session: mls.SessionConfig{
+						Disabled: false,
+						Key:      "", // Optional - derived from user and pass when empty
+					},
+				},
+			},
+		},
+	}
+}
+
+// GenerateDefault creates a default TOML config file at the specified path
+func GenerateDefault(configPath string) error {
+	defaultConfig := getDefaultConfig()
+
+	file, err := os.Create(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+	defer file.Close()
+
+	return writeConfig(file, defaultConfig)
+}
+
+// Header written at the top of every generated config file
+const configHeader = `# Application Configuration File
+# Fill in the required fields below and customize as needed
+
+`
+
+// writeConfig writes the comment header followed by [v] encoded as TOML
+func writeConfig(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, configHeader); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	// Encode the config to TOML
+	encoder := toml.NewEncoder(w)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode config to TOML: %w", err)
+	}
+
+	return nil
+}
+
+// Problem is a single issue found while loading or validating a config file
+type Problem struct {
+	Key     string
+	Line    int // 0 when the key is not present in the file
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.Key, p.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Key, p.Message)
+}
+
+// Error collects every problem found in a config file so they can be fixed in one pass
+type Error struct {
+	Path     string
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("%s: %d configuration problem(s):", e.Path, len(e.Problems)))
+	for _, problem := range e.Problems {
+		lines = append(lines, "  "+problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configProblems accumulates problems, looking up line numbers from the raw file
+type configProblems struct {
+	lines    map[string]int
+	problems []Problem
+}
+
+func (p *configProblems) add(key string, format string, args ...any) {
+	p.problems = append(p.problems, Problem{
+		Key:     key,
+		Line:    p.lines[key],
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Matches table headers such as [fub] or [[tenant]]
+var tomlTableRe = regexp.MustCompile(`^\s*(\[\[?)\s*([A-Za-z0-9_.\-]+)\s*\]\]?`)
+
+// Matches the start of a key/value pair with a bare key
+var tomlKeyRe = regexp.MustCompile(`^\s*([A-Za-z0-9_.\-]+)\s*=`)
+
+// keyLines maps each dotted key in a TOML document to the line it is defined on.
+// Keys inside an array of tables are recorded both with their index (tenant[1].fub.api_key)
+// and without it (tenant.fub.api_key, first occurrence), matching how toml.MetaData names them.
+// It only understands bare keys, which is all the generated config uses.
+func keyLines(data string) map[string]int {
+	lines := make(map[string]int)
+	record := func(key string, indexed string, line int) {
+		if _, ok := lines[key]; !ok {
+			lines[key] = line
+		}
+		if _, ok := lines[indexed]; !ok {
+			lines[indexed] = line
+		}
+	}
+
+	arrayCounts := make(map[string]int)
+	table, indexedTable := "", ""
+
+	for i, line := range strings.Split(data, "\n") {
+		if m := tomlTableRe.FindStringSubmatch(line); m != nil {
+			table = m[2]
+			if m[1] == "[[" {
+				arrayCounts[table]++
+			}
+
+			// Prefix with the index of the array table this table belongs to
+			parts := strings.Split(table, ".")
+			indexedTable = ""
+			for j, part := range parts {
+				if j > 0 {
+					indexedTable += "."
+				}
+				indexedTable += part
+				if n := arrayCounts[strings.Join(parts[:j+1], ".")]; n > 0 {
+					indexedTable += fmt.Sprintf("[%d]", n-1)
+				}
+			}
+
+			record(table, indexedTable, i+1)
+			continue
+		}
+
+		if m := tomlKeyRe.FindStringSubmatch(line); m != nil {
+			if table == "" {
+				record(m[1], m[1], i+1)
+			} else {
+				record(table+"."+m[1], indexedTable+"."+m[1], i+1)
+			}
+		}
+	}
+
+	return lines
+}
+
+// configMigrations[n] upgrades a raw version n config to version n+1
+var configMigrations = []func(raw map[string]any) error{
+	migrateConfigV0,
+	migrateConfigV1,
+}
+
+// v0 files were written before versioning existed, and the generator filled them
+// with placeholder smart lists and stages that look valid but match nothing.
+func migrateConfigV0(raw map[string]any) error {
+	fub, ok := raw["fub"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	placeholders := map[string][]string{
+		"seller_smartlist_ids": {"123", "456"},
+		"excluded_stages":      {"stage1", "stage2"},
+	}
+	for key, placeholder := range placeholders {
+		values, ok := fub[key].([]any)
+		if !ok || len(values) != len(placeholder) {
+			continue
+		}
+		matches := true
+		for i, value := range values {
+			if value != placeholder[i] {
+				matches = false
+			}
+		}
+		if matches {
+			fub[key] = []any{}
+		}
+	}
+
+	return nil
+}
+
+// v1 files hold a single FUB account, MLS login and recipient list.
+// They become the only tenant of a v2 file.
+func migrateConfigV1(raw map[string]any) error {
+	tenant := map[string]any{"name": "default"}
+
+	if fub, ok := raw["fub"]; ok {
+		tenant["fub"] = fub
+		delete(raw, "fub")
+	}
+	if mls, ok := raw["mls"]; ok {
+		tenant["mls"] = mls
+		delete(raw, "mls")
+	}
+	if smtp, ok := raw["smtp"].(map[string]any); ok {
+		if to, ok := smtp["to"]; ok {
+			tenant["report_to"] = to
+			delete(smtp, "to")
+		}
+	}
+
+	raw["tenant"] = []map[string]any{tenant}
+	return nil
+}
+
+// migrateConfig upgrades the contents of an older config file to CONFIG_VERSION in memory.
+// The result is encoded from the decoded values, so comments and key order are lost.
+// Current files are returned as they are, with migrated false.
+func migrateConfig(data []byte) (result []byte, migrated bool, err error) {
+	var raw map[string]any
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, false, fmt.Errorf("failed to decode config file: %w", err)
+	}
+
+	version := 0
+	if v, ok := raw["version"]; ok {
+		n, ok := v.(int64)
+		if !ok {
+			return nil, false, fmt.Errorf("failed to decode config file: version must be an integer")
+		}
+		version = int(n)
+	}
+
+	if version > CONFIG_VERSION {
+		return nil, false, fmt.Errorf("config version %d is newer than this build supports (%d)", version, CONFIG_VERSION)
+	}
+	if version == CONFIG_VERSION {
+		return data, false, nil
+	}
+
+	for ; version < CONFIG_VERSION; version++ {
+		if err := configMigrations[version](raw); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate config from version %d: %w", version, err)
+		}
+	}
+	raw["version"] = CONFIG_VERSION
+
+	var migratedData bytes.Buffer
+	if err := writeConfig(&migratedData, raw); err != nil {
+		return nil, false, err
+	}
+	return migratedData.Bytes(), true, nil
+}
+
+// saveMigratedConfig replaces the config file with its migrated contents, keeping a .bak copy of the original
+func saveMigratedConfig(configPath string, original []byte, migrated []byte) error {
+	if err := atomicfile.Write(configPath+".bak", original, 0o600); err != nil {
+		return fmt.Errorf("failed to back up config file: %w", err)
+	}
+	if err := atomicfile.Write(configPath, migrated, 0o600); err != nil {
+		return fmt.Errorf("failed to rewrite config file: %w", err)
+	}
+	slog.Warn("Migrated config; its comments and key order are only kept in the backup", "path", configPath, "version", CONFIG_VERSION, "backup", configPath+".bak")
+	return nil
+}
+
+// Load loads configuration from a TOML file, migrating older versions and
+// rejecting unknown keys and invalid values. An older file is only rewritten once
+// its migrated contents are valid; until then they are saved next to it as .migrated.
+func Load(configPath string) (*Config, error) {
+	original, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	data, migrated, err := migrateConfig(original)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+
+	problems := &configProblems{lines: keyLines(string(data))}
+	for _, key := range md.Undecoded() {
+		problems.add(key.String(), "unknown key")
+	}
+	validateConfig(&config, problems)
+
+	if len(problems.problems) > 0 {
+		// Problems for keys missing from the file have no line and go last
+		sort.SliceStable(problems.problems, func(i, j int) bool {
+			a, b := problems.problems[i].Line, problems.problems[j].Line
+			return a != 0 && (b == 0 || a < b)
+		})
+		if !migrated {
+			return nil, &Error{configPath, problems.problems}
+		}
+
+		// The problems' lines are those of the migrated contents, so they are fixed there
+		migratedPath := configPath + ".migrated"
+		if err := atomicfile.Write(migratedPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to save migrated config: %w", err)
+		}
+		slog.Warn("Config is from an older version and has problems once migrated; fix them in the migrated copy and replace the config with it",
+			"path", configPath, "migrated", migratedPath)
+		return nil, &Error{migratedPath, problems.problems}
+	}
+
+	if migrated {
+		if err := saveMigratedConfig(configPath, original, data); err != nil {
+			return nil, err
+		}
+	}
+	normalize(&config)
+	return &config, nil
+}
+
+// Tenant names are used as directory names under data_dir
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateConfig checks that all required fields are present and well formed
+func validateConfig(config *Config, problems *configProblems) {
+	validateMode(config.Mode, "mode", problems)
+	if len(config.Tenants) == 0 {
+		problems.add("tenant", "at least one [[tenant]] is required")
+	}
+
+	names := make(map[string]bool)
+	for i, tenant := range config.Tenants {
+		prefix := fmt.Sprintf("tenant[%d]", i)
+
+		if tenant.Name == "" {
+			problems.add(prefix+".name", "required")
+		} else if !tenantNameRe.MatchString(tenant.Name) {
+			problems.add(prefix+".name", "%q may only contain letters, digits, '-' and '_'", tenant.Name)
+		} else if names[tenant.Name] {
+			problems.add(prefix+".name", "duplicate tenant name %q", tenant.Name)
+		}
+		names[tenant.Name] = true
+		validateMode(tenant.Mode, prefix+".mode", problems)
+		validateSince(tenant.Since, prefix+".since", problems)
+
+		if len(tenant.ReportTo) == 0 {
+			problems.add(prefix+".report_to", "required")
+		}
+		for _, to := range tenant.ReportTo {
+			if _, err := mail.ParseAddress(to); err != nil {
+				problems.add(prefix+".report_to", "%q is not a valid email address", to)
+			}
+		}
+
+		validateTenantConfig(&tenant, prefix, problems)
+	}
+
+	// Check SMTP fields
+	if config.SMTP.User == "" {
+		problems.add("smtp.user", "required")
+	}
+	if config.SMTP.Pass == "" {
+		problems.add("smtp.pass", "required")
+	}
+	if config.SMTP.From == "" {
+		problems.add("smtp.from", "required")
+	} else if _, err := mail.ParseAddress(config.SMTP.From); err != nil {
+		problems.add("smtp.from", "%q is not a valid email address", config.SMTP.From)
+	}
+	if config.SMTP.Host == "" {
+		problems.add("smtp.host", "required")
+	}
+	if port, err := strconv.Atoi(config.SMTP.Port); err != nil || port < 1 || port > 65535 {
+		problems.add("smtp.port", "%q is not a valid port", config.SMTP.Port)
+	}
+
+	if _, err := logging.ParseLevel(config.Log.Level); err != nil {
+		problems.add("log.level", "%v", err)
+	}
+	if format := config.Log.Format; format != "" && format != "text" && format != "json" {
+		problems.add("log.format", "%q must be \"text\" or \"json\"", format)
+	}
+
+	if config.Daemon.Interval < 0 {
+		problems.add("daemon.interval", "cannot be negative")
+	}
+	if textfile := config.Metrics.Textfile; textfile != "" && !strings.HasSuffix(textfile, ".prom") {
+		problems.add("metrics.textfile", "%q must end in .prom for the textfile collector", textfile)
+	}
+
+	switch config.Tracing.Exporter {
+	case tracing.EXPORTER_NONE, tracing.EXPORTER_STDOUT:
+	case tracing.EXPORTER_OTLP:
+		if config.Tracing.Endpoint != "" {
+			validateURL(config.Tracing.Endpoint, "tracing.endpoint", problems)
+		}
+	default:
+		problems.add("tracing.exporter", "%q must be \"otlp\", \"stdout\" or empty", config.Tracing.Exporter)
+	}
+
+	if listen := config.Dashboard.Listen; listen != "" {
+		if _, port, err := net.SplitHostPort(listen); err != nil || port == "" {
+			problems.add("dashboard.listen", "%q is not a host:port address", listen)
+		}
+	}
+}
+
+// validateTenantConfig checks the FUB and MLS sections of a single tenant
+func validateTenantConfig(tenant *Tenant, prefix string, problems *configProblems) {
+	// Check FUB fields
+	if tenant.FUB.APIKey == "" {
+		problems.add(prefix+".fub.api_key", "required")
+	}
+	if len(tenant.FUB.SellerSmartlistIDs) == 0 && len(tenant.FUB.SellerSmartlists) == 0 {
+		problems.add(prefix+".fub.seller_smartlist_ids", "required unless fub.seller_smartlists is set")
+	}
+	for _, name := range tenant.FUB.SellerSmartlists {
+		if strings.TrimSpace(name) == "" {
+			problems.add(prefix+".fub.seller_smartlists", "smart list names cannot be blank")
+		}
+	}
+	for _, id := range tenant.FUB.SellerSmartlistIDs {
+		if n, err := strconv.Atoi(strings.TrimSpace(id)); err != nil || n <= 0 {
+			problems.add(prefix+".fub.seller_smartlist_ids", "%q is not a smart list ID", id)
+		}
+	}
+
+	stageLists := []struct {
+		key    string
+		stages []string
+	}{
+		{"excluded_stages", tenant.FUB.ExcludedStages},
+		{"included_stages", tenant.FUB.IncludedStages},
+	}
+	for _, list := range stageLists {
+		for _, stage := range list.stages {
+			if strings.TrimSpace(stage) == "" {
+				problems.add(prefix+".fub."+list.key, "stage names cannot be blank")
+			}
+		}
+	}
+	if unknown := tenant.FUB.UnknownStages; unknown != "" && unknown != "warn" && unknown != "fail" {
+		problems.add(prefix+".fub.unknown_stages", "%q must be \"warn\" or \"fail\"", unknown)
+	}
+	validateURL(tenant.FUB.BaseURL, prefix+".fub.base_url", problems)
+	if tenant.FUB.Writes.PerSecond < 0 {
+		problems.add(prefix+".fub.writes.per_second", "cannot be negative")
+	}
+	if tenant.FUB.Writes.MaxAttempts < 0 {
+		problems.add(prefix+".fub.writes.max_attempts", "cannot be negative")
+	}
+	if tenant.FUB.Writes.RetryDelay < 0 {
+		problems.add(prefix+".fub.writes.retry_delay", "cannot be negative")
+	}
+
+	// Check MLS required fields
+	if tenant.MLS.User == "" {
+		problems.add(prefix+".mls.user", "required")
+	}
+	if tenant.MLS.Pass == "" {
+		problems.add(prefix+".mls.pass", "required")
+	}
+	validateURL(tenant.MLS.LoginURL, prefix+".mls.login_url", problems)
+	validateURL(tenant.MLS.SearchURL, prefix+".mls.search_url", problems)
+	validateURL(tenant.MLS.HistoryURL, prefix+".mls.history_url", problems)
+	if tenant.MLS.HistoryURL != "" && (!strings.Contains(tenant.MLS.HistoryURL, "{id}") || !strings.Contains(tenant.MLS.HistoryURL, "{mlsid}")) {
+		problems.add(prefix+".mls.history_url", "must contain {id} and {mlsid}")
+	}
+	if _, err := tenant.MLS.Browser.ProxyURL(); err != nil {
+		problems.add(prefix+".mls.browser.proxy", "%v", err)
+	}
+	if browser := tenant.MLS.Browser; browser.RemoteURL != "" {
+		if u, err := url.Parse(browser.RemoteURL); err != nil || u.Host == "" || !slices.Contains([]string{"ws", "wss", "http", "https"}, u.Scheme) {
+			problems.add(prefix+".mls.browser.remote_url", "%q is not a ws(s) or http(s) URL", browser.RemoteURL)
+		}
+		if browser.ExecPath != "" || browser.Proxy != "" || browser.UserAgent != "" || browser.NoSandbox || browser.UserDataDir != "" {
+			problems.add(prefix+".mls.browser.remote_url", "cannot be combined with options for starting Chrome")
+		}
+	}
+	if tenant.MLS.TOTPSecret != "" {
+		if err := mls.ValidateTOTPSecret(tenant.MLS.TOTPSecret); err != nil {
+			problems.add(prefix+".mls.totp_secret", "%v", err)
+		}
+	}
+	if _, err := tenant.MLS.Location(); err != nil {
+		problems.add(prefix+".mls.time_zone", "%v", err)
+	}
+	if tenant.MLS.Cache.SearchTTL < 0 {
+		problems.add(prefix+".mls.cache.search_ttl", "cannot be negative")
+	}
+	if tenant.MLS.Cache.HistoryTTL < 0 {
+		problems.add(prefix+".mls.cache.history_ttl", "cannot be negative")
+	}
+}
+
+// validateMode checks an optional mode is one of the known ones
+func validateMode(mode string, key string, problems *configProblems) {
+	if mode != "" && mode != MODE_TAG && mode != MODE_REPORT && mode != MODE_REVIEW {
+		problems.add(key, "%q must be \"tag\", \"report\" or \"review\"", mode)
+	}
+}
+
+// validateSince checks the reference is known and has what it needs
+func validateSince(since SinceConfig, key string, problems *configProblems) {
+	switch since.Reference {
+	case "", SINCE_CREATED, SINCE_LAST_ACTIVITY, SINCE_STAGE, SINCE_SMART_LIST, SINCE_LAST_RUN:
+		if since.Lookback != 0 {
+			problems.add(key+".lookback", "only used with reference = %q", SINCE_LOOKBACK)
+		}
+	case SINCE_LOOKBACK:
+		if since.Lookback <= 0 {
+			problems.add(key+".lookback", "must be positive with reference = %q", SINCE_LOOKBACK)
+		}
+	default:
+		problems.add(key+".reference", "%q must be \"created\", \"last_activity\", \"stage\", \"smart_list\", \"lookback\" or \"last_run\"", since.Reference)
+	}
+}
+
+// validateURL checks an optional endpoint is an absolute http(s) URL
+func validateURL(value string, key string, problems *configProblems) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems.add(key, "%q is not an http(s) URL", value)
+	}
+}
+
+// normalize trims whitespace from list entries and fills in defaults
+func normalize(config *Config) {
+	if config.DataDir == "" {
+		config.DataDir = DEFAULT_DATA_DIR
+	}
+	if config.Mode == "" {
+		config.Mode = MODE_TAG
+	}
+	if config.Log.Format == "" {
+		config.Log.Format = logging.DEFAULT_FORMAT
+	}
+	if config.Log.Level == "" {
+		config.Log.Level = logging.DEFAULT_LEVEL
+	}
+	if config.Dashboard.Listen == "" {
+		config.Dashboard.Listen = dashboard.DEFAULT_LISTEN
+	}
+	if config.Daemon.Interval == 0 {
+		config.Daemon.Interval = DEFAULT_DAEMON_INTERVAL
+	}
+
+	for t := range config.Tenants {
+		if config.Tenants[t].Mode == "" {
+			config.Tenants[t].Mode = config.Mode
+		}
+		if config.Tenants[t].Since.Reference == "" {
+			config.Tenants[t].Since.Reference = SINCE_CREATED
+		}
+
+		fub := &config.Tenants[t].FUB
+
+		// Trim whitespace from seller smartlist IDs
+		for i, id := range fub.SellerSmartlistIDs {
+			fub.SellerSmartlistIDs[i] = strings.TrimSpace(id)
+		}
+
+		// Trim whitespace from seller smartlist names
+		for i, name := range fub.SellerSmartlists {
+			fub.SellerSmartlists[i] = strings.TrimSpace(name)
+		}
+
+		// Trim whitespace from excluded and included stages
+		for i, stage := range fub.ExcludedStages {
+			fub.ExcludedStages[i] = strings.TrimSpace(stage)
+		}
+		for i, stage := range fub.IncludedStages {
+			fub.IncludedStages[i] = strings.TrimSpace(stage)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validConfig = `version = 2
+
+[smtp]
+  user = "mailer"
+  pass = "secret"
+  from = "reports@example.com"
+  host = "smtp.example.com"
+  port = "587"
+
+[[tenant]]
+  name = "north"
+  report_to = ["north@example.com"]
+  [tenant.fub]
+    api_key = "key"
+    seller_smartlist_ids = [" 12 ", "34"]
+    excluded_stages = [" Trash "]
+  [tenant.mls]
+    user = "user"
+    pass = "pass"
+`
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadValid(t *testing.T) {
+	config, err := Load(writeConfigFile(t, validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Tenants) != 1 {
+		t.Fatalf("got %d tenants, want 1", len(config.Tenants))
+	}
+	tenant := config.Tenants[0]
+	if strings.Join(tenant.FUB.SellerSmartlistIDs, ",") != "12,34" || tenant.FUB.ExcludedStages[0] != "Trash" {
+		t.Errorf("lists were not trimmed: %q %q", tenant.FUB.SellerSmartlistIDs, tenant.FUB.ExcludedStages)
+	}
+	if tenant.Mode != MODE_TAG {
+		t.Errorf("mode = %q, want %q by default", tenant.Mode, MODE_TAG)
+	}
+}
+
+func TestLoadNumericSmartListIDs(t *testing.T) {
+	config, err := Load(writeConfigFile(t, strings.Replace(validConfig, `[" 12 ", "34"]`, `[12, "34"]`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := config.Tenants[0].FUB.SellerSmartlistIDs; strings.Join(ids, ",") != "12,34" {
+		t.Errorf("seller_smartlist_ids = %q, want 12 and 34", ids)
+	}
+
+	// Values that aren't IDs are reported with the rest of the problems
+	_, err = Load(writeConfigFile(t, strings.Replace(validConfig, `[" 12 ", "34"]`, `[12, 3.5]`, 1)))
+	if err == nil || !strings.Contains(err.Error(), `line 15: tenant[0].fub.seller_smartlist_ids: "3.5" is not a smart list ID`) {
+		t.Errorf("Load() error = %v, want 3.5 reported", err)
+	}
+}
+
+func TestLoadModes(t *testing.T) {
+	contents := strings.NewReplacer(
+		"version = 2\n", "version = 2\nmode = \"report\"\n",
+		`name = "north"`, "name = \"north\"\n  mode = \"approve\"",
+	).Replace(validConfig)
+
+	_, err := Load(writeConfigFile(t, contents))
+	if err == nil || !strings.Contains(err.Error(), `line 13: tenant[0].mode: "approve" must be "tag", "report" or "review"`) {
+		t.Fatalf("Load() error = %v, want invalid tenant mode", err)
+	}
+
+	// Tenants without a mode take the top-level one
+	config, err := Load(writeConfigFile(t, strings.Replace(contents, "  mode = \"approve\"\n", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Tenants[0].Mode != MODE_REPORT {
+		t.Errorf("mode = %q, want %q", config.Tenants[0].Mode, MODE_REPORT)
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	contents := strings.NewReplacer(
+		`port = "587"`, "port = \"smtp\"\n  bogus = true",
+		`"34"`, `"thirty-four"`,
+		`user = "user"`, `user = ""`,
+	).Replace(validConfig)
+
+	_, err := Load(writeConfigFile(t, contents))
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Load() error = %v, want *Error", err)
+	}
+
+	var got []string
+	for _, problem := range configErr.Problems {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		`line 8: smtp.port: "smtp" is not a valid port`,
+		`line 9: smtp.bogus: unknown key`,
+		`line 16: tenant[0].fub.seller_smartlist_ids: "thirty-four" is not a smart list ID`,
+		`line 19: tenant[0].mls.user: required`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadMigratesUnversionedConfig(t *testing.T) {
+	original := `[fub]
+  api_key = "key"
+  seller_smartlist_ids = ["123", "456"]
+  excluded_stages = ["stage1", "stage2"]
+
+[mls]
+  user = "user"
+  pass = "pass"
+
+[smtp]
+  user = "mailer"
+  pass = "secret"
+  from = "reports@example.com"
+  to = ["team@example.com"]
+  host = "smtp.example.com"
+  port = "587"
+`
+	path := writeConfigFile(t, original)
+
+	// Placeholder smart lists are dropped, so the migrated file still needs editing.
+	// The original is left alone and the migrated contents are saved next to it.
+	_, err := Load(path)
+	var configErr *Error
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 1 || configErr.Problems[0].Key != "tenant[0].fub.seller_smartlist_ids" {
+		t.Fatalf("Load() error = %v, want only missing smart lists", err)
+	}
+	if configErr.Path != path+".migrated" {
+		t.Errorf("problems reported in %s, want the migrated copy", configErr.Path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("config with problems was rewritten:\n%s", data)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("config with problems was backed up: %v", err)
+	}
+
+	migrated, err := os.ReadFile(path + ".migrated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"version = 2", "[[tenant]]", `name = "default"`, `report_to = ["team@example.com"]`} {
+		if !strings.Contains(string(migrated), want) {
+			t.Errorf("migrated config missing %q:\n%s", want, migrated)
+		}
+	}
+
+	// A config that is valid once migrated is rewritten in place, keeping the original as a backup
+	original = strings.Replace(original, `["123", "456"]`, `["789"]`, 1)
+	path = writeConfigFile(t, original)
+	if _, err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if backup, _ := os.ReadFile(path + ".bak"); string(backup) != original {
+		t.Errorf("backup = %q, want the original", backup)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "[[tenant]]") {
+		t.Errorf("config was not migrated:\n%s", data)
+	}
+}
+
+func TestLoadRejectsNewerVersion(t *testing.T) {
+	if _, err := Load(writeConfigFile(t, "version = 99\n")); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Load() error = %v, want version error", err)
+	}
+}
+
+func TestGenerateDefaultNeedsEditing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := GenerateDefault(path); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path)
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Load() error = %v, want *Error", err)
+	}
+	for _, problem := range configErr.Problems {
+		if !strings.HasPrefix(problem.Message, "required") || problem.Line == 0 {
+			t.Errorf("unexpected problem %s", problem)
+		}
+	}
+}
+
+func TestLoadValidatesEndpoints(t *testing.T) {
+	contents := strings.NewReplacer(
+		`api_key = "key"`, "api_key = \"key\"\n    base_url = \"api.example.com\"",
+		`pass = "pass"`, "pass = \"pass\"\n    history_url = \"https://mls.example.com/history?id={id}\"",
+	).Replace(validConfig)
+
+	_, err := Load(writeConfigFile(t, contents))
+	if err == nil {
+		t.Fatal("expected endpoint problems")
+	}
+	for _, want := range []string{
+		`line 15: tenant[0].fub.base_url: "api.example.com" is not an http(s) URL`,
+		`line 21: tenant[0].mls.history_url: must contain {id} and {mlsid}`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadValidatesTOTPSecret(t *testing.T) {
+	contents := strings.Replace(validConfig, `pass = "pass"`, "pass = \"pass\"\n    totp_secret = \"not base32!\"", 1)
+
+	_, err := Load(writeConfigFile(t, contents))
+	if err == nil || !strings.Contains(err.Error(), `line 20: tenant[0].mls.totp_secret: TOTP secret is not valid base32`) {
+		t.Errorf("error = %v", err)
+	}
+
+	contents = strings.Replace(validConfig, `pass = "pass"`, "pass = \"pass\"\n    totp_secret = \"JBSW Y3DP EHPK 3PXP\"", 1)
+	if _, err := Load(writeConfigFile(t, contents)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadValidatesTimeZone(t *testing.T) {
+	contents := strings.Replace(validConfig, `pass = "pass"`, "pass = \"pass\"\n    time_zone = \"Central\"", 1)
+
+	_, err := Load(writeConfigFile(t, contents))
+	if err == nil || !strings.Contains(err.Error(), `line 20: tenant[0].mls.time_zone: unknown time zone "Central"`) {
+		t.Errorf("error = %v", err)
+	}
+
+	contents = strings.Replace(validConfig, `pass = "pass"`, "pass = \"pass\"\n    time_zone = \"America/Chicago\"", 1)
+	if _, err := Load(writeConfigFile(t, contents)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadValidatesSince(t *testing.T) {
+	tests := []struct {
+		since string
+		want  string
+	}{
+		{`reference = "updated"`, `line 14: tenant[0].since.reference: "updated" must be "created", "last_activity", "stage", "smart_list", "lookback" or "last_run"`},
+		{`reference = "lookback"`, `tenant[0].since.lookback: must be positive with reference = "lookback"`},
+		{"reference = \"stage\"\n    lookback = \"24h\"", `line 15: tenant[0].since.lookback: only used with reference = "lookback"`},
+		{"reference = \"lookback\"\n    lookback = \"8760h\"\n    grace_days = 3", ""},
+		{`grace_days = -2`, ""},
+	}
+	for _, tt := range tests {
+		contents := strings.Replace(validConfig, "  [tenant.fub]", "  [tenant.since]\n    "+tt.since+"\n  [tenant.fub]", 1)
+		cfg, err := Load(writeConfigFile(t, contents))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.since, err)
+			} else if cfg.Tenants[0].Since.Reference == "" {
+				t.Errorf("%s: reference was not defaulted", tt.since)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.since, err, tt.want)
+		}
+	}
+}
+
+func TestLoadValidatesBrowser(t *testing.T) {
+	contents := strings.Replace(validConfig, `pass = "pass"`, `pass = "pass"
+    [tenant.mls.browser]
+      no_sandbox = true
+      proxy = "http://"
+      remote_url = "127.0.0.1:9222"`, 1)
+
+	_, err := Load(writeConfigFile(t, contents))
+	if err == nil {
+		t.Fatal("expected browser problems")
+	}
+	for _, want := range []string{
+		`line 22: tenant[0].mls.browser.proxy: proxy "http://" is not a valid URL`,
+		`line 23: tenant[0].mls.browser.remote_url: "127.0.0.1:9222" is not a ws(s) or http(s) URL`,
+		`line 23: tenant[0].mls.browser.remote_url: cannot be combined with options for starting Chrome`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+}
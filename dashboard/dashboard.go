@@ -0,0 +1,228 @@
+// Package dashboard serves a local web page of run history, flagged leads and lookup details
+package dashboard
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"for-sale-report/history"
+)
+
+const DEFAULT_LISTEN = "127.0.0.1:8080"
+
+// Config represents dashboard-related configuration
+type Config struct {
+	Listen string `toml:"listen"` // host:port, DEFAULT_LISTEN when empty
+}
+
+// Tenant is where the dashboard finds one tenant's run history
+type Tenant struct {
+	Name    string
+	RunsDir string
+}
+
+// RecheckFunc checks one person again, returning the ID of the run it was recorded as
+type RecheckFunc func(ctx context.Context, tenant string, personID int) (runID string, err error)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.DateOnly)
+	},
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.DateTime)
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	},
+}).ParseFS(templateFS, "templates/*.html"))
+
+// Server serves the dashboard pages
+type Server struct {
+	tenants []Tenant
+	recheck RecheckFunc
+	mux     *http.ServeMux
+}
+
+// New creates a dashboard over the given tenants' history. Re-checking is disabled when recheck is nil.
+func New(tenants []Tenant, recheck RecheckFunc) *Server {
+	s := &Server{tenants: tenants, recheck: recheck, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /{$}", s.handleRuns)
+	s.mux.HandleFunc("GET /runs/{tenant}/{run}", s.handleRun)
+	s.mux.HandleFunc("GET /people/{tenant}/{person}", s.handlePerson)
+	s.mux.HandleFunc("POST /people/{tenant}/{person}/recheck", s.handleRecheck)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves handler on addr until ctx is done
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.InfoContext(ctx, "Dashboard listening", "url", "http://"+addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) tenant(name string) (Tenant, bool) {
+	index := slices.IndexFunc(s.tenants, func(tenant Tenant) bool { return tenant.Name == name })
+	if index == -1 {
+		return Tenant{}, false
+	}
+	return s.tenants[index], true
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	runs := make([]*history.Run, 0)
+	for _, tenant := range s.tenants {
+		tenantRuns, err := history.Runs(tenant.RunsDir)
+		if err != nil {
+			s.error(w, http.StatusInternalServerError, fmt.Errorf("%s: %w", tenant.Name, err))
+			return
+		}
+		runs = append(runs, tenantRuns...)
+	}
+	slices.SortStableFunc(runs, func(a, b *history.Run) int { return b.Started.Compare(a.Started) })
+
+	s.render(w, "runs.html", map[string]any{"Runs": runs})
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.tenant(r.PathValue("tenant"))
+	if !ok {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown tenant %q", r.PathValue("tenant")))
+		return
+	}
+
+	run, err := history.Load(tenant.RunsDir, r.PathValue("run"))
+	if errors.Is(err, fs.ErrNotExist) {
+		s.error(w, http.StatusNotFound, fmt.Errorf("run %q not found", r.PathValue("run")))
+		return
+	}
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.render(w, "run.html", map[string]any{"Run": run})
+}
+
+func (s *Server) handlePerson(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.tenant(r.PathValue("tenant"))
+	if !ok {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown tenant %q", r.PathValue("tenant")))
+		return
+	}
+	personID, err := strconv.Atoi(r.PathValue("person"))
+	if err != nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("%q is not a person ID", r.PathValue("person")))
+		return
+	}
+
+	runs, err := history.Runs(tenant.RunsDir)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	checks := history.PersonHistory(runs, personID)
+
+	name := ""
+	if len(checks) > 0 {
+		name = checks[0].Name
+	}
+	s.render(w, "person.html", map[string]any{
+		"Tenant":     tenant.Name,
+		"PersonID":   personID,
+		"Name":       name,
+		"Checks":     checks,
+		"CanRecheck": s.recheck != nil,
+	})
+}
+
+func (s *Server) handleRecheck(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		s.error(w, http.StatusForbidden, fmt.Errorf("cross-origin request rejected"))
+		return
+	}
+	if s.recheck == nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("re-checking is not available"))
+		return
+	}
+	tenant, ok := s.tenant(r.PathValue("tenant"))
+	if !ok {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown tenant %q", r.PathValue("tenant")))
+		return
+	}
+	personID, err := strconv.Atoi(r.PathValue("person"))
+	if err != nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("%q is not a person ID", r.PathValue("person")))
+		return
+	}
+
+	runID, err := s.recheck(r.Context(), tenant.Name, personID)
+	if err != nil {
+		s.error(w, http.StatusBadGateway, fmt.Errorf("re-check of %d failed: %w", personID, err))
+		return
+	}
+	http.Redirect(w, r, "/runs/"+url.PathEscape(tenant.Name)+"/"+url.PathEscape(runID), http.StatusSeeOther)
+}
+
+// sameOrigin rejects form posts from other sites, since the dashboard has no login
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		slog.Warn("Dashboard failed to render page", "template", name, "error", err)
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "error.html", map[string]any{"Status": status, "Error": err.Error()}); err != nil {
+		slog.Warn("Dashboard failed to render error page", "error", err)
+	}
+}
@@ -0,0 +1,115 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/mls"
+)
+
+func newTestServer(t *testing.T, recheck RecheckFunc) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	run := history.Start(dir, "north", "run-1", "tag")
+	run.AddSmartLists([]fub.SmartListStats{{SmartList: fub.SmartList{ID: 3, Name: "Past Sellers"}, People: 2}})
+	run.AddCheck(history.Check{PersonID: 1, Name: "Ada", Address: "1 Main St, Springfield", Result: history.RESULT_SOLD, Match: &mls.Match{ID: "20001", MlsID: "M-1"}})
+	run.AddCheck(history.Check{PersonID: 2, Name: "Grace <script>", Result: history.RESULT_ERROR, Error: "No results found"})
+	if err := run.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(New([]Tenant{{Name: "north", RunsDir: dir}}, recheck))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestPages(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	tests := []struct {
+		path   string
+		status int
+		want   []string
+	}{
+		{"/", 200, []string{`href="/runs/north/run-1"`, "<td>2</td>"}},
+		{"/runs/north/run-1", 200, []string{"Past Sellers", "M-1 (20001)", "No results found", "Grace &lt;script&gt;"}},
+		{"/people/north/1", 200, []string{"Person 1 Ada", `href="/runs/north/run-1"`, "1 Main St, Springfield"}},
+		{"/runs/north/run-9", 404, []string{`run &#34;run-9&#34; not found`}},
+		{"/runs/north/..%2F..%2Fsecret", 404, []string{`run &#34;../../secret&#34; not found`}},
+		{"/runs/south/run-1", 404, []string{"unknown tenant"}},
+		{"/people/north/abc", 404, []string{"not a person ID"}},
+	}
+	for _, test := range tests {
+		status, body := get(t, server.URL+test.path)
+		if status != test.status {
+			t.Errorf("GET %s = %d, want %d", test.path, status, test.status)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s missing %q", test.path, want)
+			}
+		}
+	}
+
+	// Re-checking is only offered when it is available
+	if _, body := get(t, server.URL+"/people/north/1"); strings.Contains(body, "Re-check") {
+		t.Error("re-check offered without a RecheckFunc")
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	var rechecked []string
+	server := newTestServer(t, func(ctx context.Context, tenant string, personID int) (string, error) {
+		rechecked = append(rechecked, fmt.Sprintf("%s/%d", tenant, personID))
+		return "run-1", nil
+	})
+
+	if _, body := get(t, server.URL+"/people/north/2"); !strings.Contains(body, `action="/people/north/2/recheck"`) {
+		t.Error("person page has no re-check form")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Post(server.URL+"/people/north/2/recheck", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/runs/north/run-1" {
+		t.Errorf("POST recheck = %d to %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// Other sites can't trigger re-checks
+	req, _ := http.NewRequest("POST", server.URL+"/people/north/2/recheck", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin POST = %d, want 403", res.StatusCode)
+	}
+
+	if fmt.Sprint(rechecked) != "[north/2]" {
+		t.Errorf("rechecked = %v", rechecked)
+	}
+}
@@ -0,0 +1,84 @@
+package fub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized = errors.New("fub: unauthorized") // Bad API key or missing permission; fatal
+	ErrNotFound     = errors.New("fub: not found")    // Person or resource doesn't exist
+	ErrRateLimited  = errors.New("fub: rate limited") // Too many requests; retryable
+)
+
+// APIError is a non-2xx response from FUB.
+// It matches ErrUnauthorized, ErrNotFound or ErrRateLimited with errors.Is depending on Status.
+type APIError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration // From the Retry-After header, 0 if not sent
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("fub: %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// IsRetryable reports whether a failed request may succeed if sent again:
+// rate limits, server errors and network failures. Anything else needs fixing first.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500
+	}
+
+	// Decoding and other local errors won't change on a retry; transport errors might
+	var netErr interface{ Timeout() bool }
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// checkResponse turns a non-2xx response into an *APIError, reading FUB's
+// {"errorMessage": "..."} body when there is one
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+
+	var jsonErr struct {
+		ErrorMessage string `json:"errorMessage"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &jsonErr) == nil && jsonErr.ErrorMessage != "" {
+		message = jsonErr.ErrorMessage
+	}
+
+	apiErr := &APIError{Status: res.StatusCode, Message: message}
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
@@ -0,0 +1,92 @@
+package fub_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"for-sale-report/fub"
+)
+
+func TestResponseErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		retryAfter string
+		sentinel   error
+		message    string
+		retryable  bool
+	}{
+		{"unauthorized", 401, `{"errorMessage":"Invalid API key"}`, "", fub.ErrUnauthorized, "Invalid API key", false},
+		{"forbidden", 403, `{"errorMessage":"Access denied"}`, "", fub.ErrUnauthorized, "Access denied", false},
+		{"not found", 404, `{"errorMessage":"Person not found"}`, "", fub.ErrNotFound, "Person not found", false},
+		{"rate limited", 429, `{"errorMessage":"Slow down"}`, "7", fub.ErrRateLimited, "Slow down", true},
+		{"server error", 502, `<html>Bad Gateway</html>`, "", nil, "<html>Bad Gateway</html>", true},
+		{"bad request", 400, `{"errorMessage":"Invalid tags"}`, "", nil, "Invalid tags", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			client, err := fub.New(fub.Config{BaseURL: server.URL, SellerSmartlistIDs: []string{"1"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Reads and writes report errors the same way
+			_, _, _, readErr := client.GetPeoplePage(context.Background(), 1, "")
+			writeErr := client.UpdatePerson(context.Background(), 1, []string{fub.SOLD_TAG}, "")
+
+			for _, err := range []error{readErr, writeErr} {
+				var apiErr *fub.APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("got %v, want *fub.APIError", err)
+				}
+				if apiErr.Status != test.status || apiErr.Message != test.message {
+					t.Errorf("got status %d message %q", apiErr.Status, apiErr.Message)
+				}
+				if test.sentinel != nil && !errors.Is(err, test.sentinel) {
+					t.Errorf("errors.Is(%v, %v) = false", err, test.sentinel)
+				}
+				if fub.IsRetryable(err) != test.retryable {
+					t.Errorf("IsRetryable(%v) = %v", err, !test.retryable)
+				}
+				if test.retryAfter != "" && apiErr.RetryAfter != 7*time.Second {
+					t.Errorf("RetryAfter = %v", apiErr.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRetryableNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client, err := fub.New(fub.Config{BaseURL: server.URL, SellerSmartlistIDs: []string{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.UpdatePerson(ctx, 1, []string{fub.SOLD_TAG}, ""); !fub.IsRetryable(err) {
+		t.Errorf("IsRetryable(%v) = false for a refused connection", err)
+	}
+
+	cancel()
+	if err := client.UpdatePerson(ctx, 1, []string{fub.SOLD_TAG}, ""); fub.IsRetryable(err) {
+		t.Errorf("IsRetryable(%v) = true for a cancelled context", err)
+	}
+}
@@ -0,0 +1,636 @@
+// Package fub is a small client for the Follow Up Boss API
+package fub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"for-sale-report/logging"
+	"for-sale-report/tracing"
+)
+
+const DEFAULT_BASE_URL = "https://api.followupboss.com"
+const SYSTEM_HEADER = "ForSaleReport"                 // X-System
+const SYSTEM_KEY = "e50150b78203e92245f6407fdea50dab" // X-System-Key
+const BUFFER_AMOUNT = 100                             // How many to get per request
+const SOLD_TAG = "Expired Lead"                       // Tag added to people whose address has sold
+
+var tracer = otel.Tracer("for-sale-report/fub")
+
+// SmartListIDs are smart list IDs as written in the config file, where they may be numbers or strings
+type SmartListIDs []string
+
+// UnmarshalTOML accepts both [123, 456] and ["123", "456"]. Other values are kept as written,
+// so validation can report them along with any other problems.
+func (ids *SmartListIDs) UnmarshalTOML(value any) error {
+	values, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("smart list IDs must be an array, not %T", value)
+	}
+	*ids = make(SmartListIDs, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			*ids = append(*ids, s)
+		} else {
+			*ids = append(*ids, fmt.Sprint(v))
+		}
+	}
+	return nil
+}
+
+// Config represents FUB-related configuration
+type Config struct {
+	BaseURL            string       `toml:"base_url"` // DEFAULT_BASE_URL when empty
+	APIKey             string       `toml:"api_key"`
+	SellerSmartlistIDs SmartListIDs `toml:"seller_smartlist_ids"`
+	SellerSmartlists   []string     `toml:"seller_smartlists"` // Names, resolved through the API
+	ExcludedStages     []string     `toml:"excluded_stages"`
+	IncludedStages     []string     `toml:"included_stages"` // When set, only people in these stages are processed
+	UnknownStages      string       `toml:"unknown_stages"`  // "warn" (default) or "fail" for stages FUB doesn't have
+	Writes             WriteConfig  `toml:"writes"`
+
+	// HTTPClient is used for every request; http.DefaultClient when nil
+	HTTPClient *http.Client `toml:"-"`
+}
+
+type Client struct {
+	baseURL         string
+	token           string
+	sellerListIds   []int
+	sellerListNames []string
+	excludedStages  []string
+	includedStages  []string
+	unknownStages   string
+	client          *http.Client
+}
+
+// Next is an opaque cursor for the following page, nil on the last page.
+// NextLink is the same cursor as a full URL, which always points at the production API.
+type Metadata struct {
+	Next     *string `json:"next"`
+	NextLink *string `json:"nextLink"`
+	Total    int     `json:"total"`
+}
+
+type PersonAddress struct {
+	Type    string `json:"type"`
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Code    string `json:"code"`
+	Country string `json:"country"`
+}
+
+type PersonEmail struct {
+	Value     string `json:"value"`
+	Type      string `json:"type"`
+	IsPrimary int    `json:"isPrimary"`
+	Status    string `json:"status"`
+}
+
+type PersonPhone struct {
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Status string `json:"status"`
+}
+
+type Person struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	CreatedAt    time.Time       `json:"created"`
+	LastActivity time.Time       `json:"lastActivity"` // Zero when there has been none
+	Stage        string          `json:"stage"`
+	Tags         []string        `json:"tags"`
+	Addresses    []PersonAddress `json:"addresses"`
+}
+
+type PeopleResponse struct {
+	Metadata Metadata `json:"_metadata"`
+	People   []Person `json:"people"`
+}
+
+type SmartList struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Stage struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type StagesResponse struct {
+	Metadata Metadata `json:"_metadata"`
+	Stages   []Stage  `json:"stages"`
+}
+
+// ListedPerson is a person together with every seller smart list they appear in
+type ListedPerson struct {
+	Person
+	SmartLists []SmartList
+}
+
+// SmartListNames returns the names of the lists the person appears in
+func (p *ListedPerson) SmartListNames() []string {
+	names := make([]string, 0, len(p.SmartLists))
+	for _, smartList := range p.SmartLists {
+		names = append(names, smartList.Name)
+	}
+	return names
+}
+
+type SmartListsResponse struct {
+	Metadata   Metadata    `json:"_metadata"`
+	SmartLists []SmartList `json:"smartlists"`
+}
+
+func New(config Config) (*Client, error) {
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DEFAULT_BASE_URL
+	}
+
+	// Convert string IDs to integers
+	sellerListIds := make([]int, 0, len(config.SellerSmartlistIDs))
+
+	for _, idStr := range config.SellerSmartlistIDs {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
+		sellerListId, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, err
+		}
+		sellerListIds = append(sellerListIds, sellerListId)
+	}
+
+	sellerListNames := make([]string, 0, len(config.SellerSmartlists))
+	for _, name := range config.SellerSmartlists {
+		if name = strings.TrimSpace(name); name != "" {
+			sellerListNames = append(sellerListNames, name)
+		}
+	}
+
+	if len(sellerListIds) == 0 && len(sellerListNames) == 0 {
+		return nil, fmt.Errorf("No valid smart list IDs or names provided")
+	}
+
+	return &Client{
+		baseURL,
+		config.APIKey,
+		sellerListIds,
+		sellerListNames,
+		config.ExcludedStages,
+		config.IncludedStages,
+		config.UnknownStages,
+		client,
+	}, nil
+}
+
+func (f *Client) newRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Basic auth requires Base64 encoding of API key
+	auth := base64.StdEncoding.EncodeToString([]byte(f.token + ":"))
+
+	req.Header.Add("X-System", SYSTEM_HEADER)
+	req.Header.Add("X-System-Key", SYSTEM_KEY)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("authorization", "Basic "+auth)
+
+	if body != nil && (method == "POST" || method == "PUT" || method == "PATCH") {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// GetPeoplePage fetches up to BUFFER_AMOUNT people from a smart list.
+// Pass an empty cursor for the first page, then the returned next cursor until it is empty.
+func (f *Client) GetPeoplePage(ctx context.Context, smartListId int, cursor string) (people []Person, next string, total int, err error) {
+	ctx, span := tracer.Start(ctx, "fub.GetPeoplePage", trace.WithAttributes(
+		attribute.Int(logging.KEY_SMART_LIST_ID, smartListId),
+		attribute.Bool("first_page", cursor == ""),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("people", len(people)), attribute.Int("total", total))
+		tracing.End(span, &err)
+	}()
+
+	query := url.Values{}
+	query.Set("sort", "created")
+	query.Set("limit", strconv.Itoa(BUFFER_AMOUNT))
+	query.Set("includeTrash", "false")
+	query.Set("includeUnclaimed", "true")
+	query.Set("fields", "id,name,created,lastActivity,stage,addresses")
+	query.Set("smartListId", strconv.Itoa(smartListId))
+	if cursor != "" {
+		query.Set("next", cursor)
+	}
+
+	req, err := f.newRequest(ctx, "GET", f.baseURL+"/v1/people?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return nil, "", 0, err
+	}
+
+	var jsonRes PeopleResponse
+	err = json.NewDecoder(res.Body).Decode(&jsonRes)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if jsonRes.Metadata.Next != nil {
+		next = *jsonRes.Metadata.Next
+	}
+	return jsonRes.People, next, jsonRes.Metadata.Total, nil
+}
+
+// People iterates over every person in a smart list, following FUB's next cursor between pages.
+// Iteration stops after the first error.
+func (f *Client) People(ctx context.Context, smartListId int) iter.Seq2[Person, error] {
+	return func(yield func(Person, error) bool) {
+		cursor := ""
+		seen := 0
+
+		for {
+			people, next, total, err := f.GetPeoplePage(ctx, smartListId, cursor)
+			if err != nil {
+				yield(Person{}, err)
+				return
+			}
+
+			seen += len(people)
+			slog.InfoContext(ctx, "Fetched smart list page", logging.KEY_SMART_LIST_ID, smartListId, "seen", seen, "total", total)
+
+			for _, person := range people {
+				if !yield(person, nil) {
+					return
+				}
+			}
+
+			if next == "" {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// SmartListStats is how many people a smart list returned and how long paging through it took
+type SmartListStats struct {
+	SmartList
+	People   int
+	Duration time.Duration
+}
+
+// CollectPeople gathers the union of people across smart lists, keyed by Person.ID,
+// so each person is returned once in the order first seen, with every list they came from
+func (f *Client) CollectPeople(ctx context.Context, smartLists []SmartList) ([]ListedPerson, []SmartListStats, error) {
+	people := make([]ListedPerson, 0)
+	stats := make([]SmartListStats, 0, len(smartLists))
+	indexes := make(map[int]int)
+
+	for _, smartList := range smartLists {
+		listStats := SmartListStats{SmartList: smartList}
+		start := time.Now()
+
+		for person, err := range f.People(ctx, smartList.ID) {
+			if err != nil {
+				return nil, nil, fmt.Errorf("smart list %v (%s): %w", smartList.ID, smartList.Name, err)
+			}
+			listStats.People++
+
+			if index, ok := indexes[person.ID]; ok {
+				people[index].SmartLists = append(people[index].SmartLists, smartList)
+				continue
+			}
+			indexes[person.ID] = len(people)
+			people = append(people, ListedPerson{person, []SmartList{smartList}})
+		}
+
+		listStats.Duration = time.Since(start)
+		stats = append(stats, listStats)
+	}
+
+	return people, stats, nil
+}
+
+// SmartLists returns every smart list visible to the API key
+func (f *Client) SmartLists(ctx context.Context) ([]SmartList, error) {
+	smartLists := make([]SmartList, 0)
+	cursor := ""
+
+	for {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(BUFFER_AMOUNT))
+		if cursor != "" {
+			query.Set("next", cursor)
+		}
+
+		req, err := f.newRequest(ctx, "GET", f.baseURL+"/v1/smartLists?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := f.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var jsonRes SmartListsResponse
+		err = checkResponse(res)
+		if err == nil {
+			err = json.NewDecoder(res.Body).Decode(&jsonRes)
+		}
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		smartLists = append(smartLists, jsonRes.SmartLists...)
+		if jsonRes.Metadata.Next == nil || *jsonRes.Metadata.Next == "" {
+			return smartLists, nil
+		}
+		cursor = *jsonRes.Metadata.Next
+	}
+}
+
+// SellerLists resolves the configured smart list IDs and names against the API.
+// Names match case-insensitively. Every list that can't be found is reported in one error.
+func (f *Client) SellerLists(ctx context.Context) ([]SmartList, error) {
+	available, err := f.SmartLists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch smart lists: %w", err)
+	}
+
+	resolved := make([]SmartList, 0, len(f.sellerListIds)+len(f.sellerListNames))
+	missing := make([]string, 0)
+	add := func(list SmartList) {
+		if !slices.Contains(resolved, list) {
+			resolved = append(resolved, list)
+		}
+	}
+
+	for _, id := range f.sellerListIds {
+		index := slices.IndexFunc(available, func(list SmartList) bool { return list.ID == id })
+		if index == -1 {
+			missing = append(missing, strconv.Itoa(id))
+			continue
+		}
+		add(available[index])
+	}
+
+	for _, name := range f.sellerListNames {
+		index := slices.IndexFunc(available, func(list SmartList) bool { return strings.EqualFold(list.Name, name) })
+		if index == -1 {
+			missing = append(missing, strconv.Quote(name))
+			continue
+		}
+		add(available[index])
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("smart lists not found: %s (run list-smartlists to see the available ones)", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// UpdatePerson adds tags to [id], keeping existing ones, and moves them to stage when it isn't empty
+func (f *Client) UpdatePerson(ctx context.Context, id int, tags []string, stage string) error {
+	payload := map[string]any{}
+	if len(tags) > 0 {
+		payload["tags"] = tags
+	}
+	if stage != "" {
+		payload["stage"] = stage
+	}
+
+	if err := f.sendJSON(ctx, "PUT", "/v1/people/"+strconv.Itoa(id)+"?mergeTags=true", payload, nil); err != nil {
+		return fmt.Errorf("%v: Failed to update person - %w", id, err)
+	}
+	return nil
+}
+
+// GetPerson fetches one person, including their tags
+func (f *Client) GetPerson(ctx context.Context, id int) (*Person, error) {
+	var person Person
+	if err := f.sendJSON(ctx, "GET", "/v1/people/"+strconv.Itoa(id)+"?fields=id,name,created,lastActivity,stage,tags,addresses", nil, &person); err != nil {
+		return nil, fmt.Errorf("%v: Failed to get person - %w", id, err)
+	}
+	return &person, nil
+}
+
+// SetPerson replaces [id]'s tags and stage, removing any tags not given
+func (f *Client) SetPerson(ctx context.Context, id int, tags []string, stage string) error {
+	payload := map[string]any{"tags": tags, "stage": stage}
+	if tags == nil {
+		payload["tags"] = []string{}
+	}
+
+	if err := f.sendJSON(ctx, "PUT", "/v1/people/"+strconv.Itoa(id)+"?mergeTags=false", payload, nil); err != nil {
+		return fmt.Errorf("%v: Failed to update person - %w", id, err)
+	}
+	return nil
+}
+
+// created is the part of a POST response needed to undo it later
+type created struct {
+	ID int `json:"id"`
+}
+
+// AddNote adds a plain text note to [personId], returning the note's ID
+func (f *Client) AddNote(ctx context.Context, personId int, body string) (int, error) {
+	var note created
+	payload := map[string]any{"personId": personId, "subject": SYSTEM_HEADER, "body": body}
+	if err := f.sendJSON(ctx, "POST", "/v1/notes", payload, &note); err != nil {
+		return 0, fmt.Errorf("%v: Failed to add note - %w", personId, err)
+	}
+	return note.ID, nil
+}
+
+// AddTask creates a task on [personId], returning the task's ID
+func (f *Client) AddTask(ctx context.Context, personId int, task Task) (int, error) {
+	var createdTask created
+	payload := map[string]any{"personId": personId, "name": task.Name}
+	if task.DueDate != "" {
+		payload["dueDate"] = task.DueDate
+	}
+	if err := f.sendJSON(ctx, "POST", "/v1/tasks", payload, &createdTask); err != nil {
+		return 0, fmt.Errorf("%v: Failed to add task - %w", personId, err)
+	}
+	return createdTask.ID, nil
+}
+
+// DeleteNote removes a note by ID
+func (f *Client) DeleteNote(ctx context.Context, id int) error {
+	if err := f.sendJSON(ctx, "DELETE", "/v1/notes/"+strconv.Itoa(id), nil, nil); err != nil {
+		return fmt.Errorf("note %v: Failed to delete - %w", id, err)
+	}
+	return nil
+}
+
+// DeleteTask removes a task by ID
+func (f *Client) DeleteTask(ctx context.Context, id int) error {
+	if err := f.sendJSON(ctx, "DELETE", "/v1/tasks/"+strconv.Itoa(id), nil, nil); err != nil {
+		return fmt.Errorf("task %v: Failed to delete - %w", id, err)
+	}
+	return nil
+}
+
+// sendJSON sends payload (when not nil) to path, checks the response and decodes it into out (when not nil)
+func (f *Client) sendJSON(ctx context.Context, method string, path string, payload any, out any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := f.newRequest(ctx, method, f.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Stages returns every stage defined in the account
+func (f *Client) Stages(ctx context.Context) ([]Stage, error) {
+	stages := make([]Stage, 0)
+	cursor := ""
+
+	for {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(BUFFER_AMOUNT))
+		if cursor != "" {
+			query.Set("next", cursor)
+		}
+
+		var jsonRes StagesResponse
+		if err := f.sendJSON(ctx, "GET", "/v1/stages?"+query.Encode(), nil, &jsonRes); err != nil {
+			return nil, err
+		}
+
+		stages = append(stages, jsonRes.Stages...)
+		if jsonRes.Metadata.Next == nil || *jsonRes.Metadata.Next == "" {
+			return stages, nil
+		}
+		cursor = *jsonRes.Metadata.Next
+	}
+}
+
+// ResolveStages checks the configured excluded and included stages against the account's stages,
+// replacing them with FUB's spelling. Unknown stages are dropped with a warning, or returned as
+// an error when unknown_stages is "fail".
+func (f *Client) ResolveStages(ctx context.Context) error {
+	if len(f.excludedStages) == 0 && len(f.includedStages) == 0 {
+		return nil
+	}
+
+	stages, err := f.Stages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch stages: %w", err)
+	}
+
+	unknown := make([]string, 0)
+	resolve := func(names []string) []string {
+		resolved := make([]string, 0, len(names))
+		for _, name := range names {
+			index := slices.IndexFunc(stages, func(stage Stage) bool { return strings.EqualFold(stage.Name, name) })
+			if index == -1 {
+				unknown = append(unknown, strconv.Quote(name))
+				continue
+			}
+			resolved = append(resolved, stages[index].Name)
+		}
+		return resolved
+	}
+
+	excluded := resolve(f.excludedStages)
+	included := resolve(f.includedStages)
+
+	if len(unknown) > 0 {
+		if f.unknownStages == "fail" {
+			return fmt.Errorf("unknown stages: %s", strings.Join(unknown, ", "))
+		}
+		slog.WarnContext(ctx, "Ignoring unknown stages", "stages", unknown)
+
+		// Dropping every included stage would silently widen the scan to everyone
+		if len(f.includedStages) > 0 && len(included) == 0 {
+			return fmt.Errorf("none of the included stages exist: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	f.excludedStages = excluded
+	f.includedStages = included
+	return nil
+}
+
+// PersonIsExcluded reports whether person's stage is excluded, or missing from the
+// included stages when those are set. Stage names match case-insensitively.
+func (f *Client) PersonIsExcluded(person *Person) bool {
+	matches := func(stage string) bool { return strings.EqualFold(stage, person.Stage) }
+
+	if len(f.includedStages) > 0 && !slices.ContainsFunc(f.includedStages, matches) {
+		return true
+	}
+	return slices.ContainsFunc(f.excludedStages, matches)
+}
+
+func (addr *PersonAddress) ToString() string {
+	// Invalid address
+	if addr.Street == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s, %s", addr.Street, addr.City)
+}
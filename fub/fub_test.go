@@ -0,0 +1,273 @@
+package fub_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/fakefub"
+)
+
+func newClient(t *testing.T, server *fakefub.Server, apiKey string) *fub.Client {
+	t.Helper()
+	client, err := fub.New(fub.Config{
+		APIKey:             apiKey,
+		SellerSmartlistIDs: []string{" 3 "},
+		ExcludedStages:     []string{"Trash"},
+		BaseURL:            server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewRejectsInvalidSmartListIDs(t *testing.T) {
+	if _, err := fub.New(fub.Config{SellerSmartlistIDs: []string{"abc"}}); err == nil {
+		t.Error("expected an error for a non-numeric smart list ID")
+	}
+	if _, err := fub.New(fub.Config{SellerSmartlistIDs: []string{" "}}); err == nil {
+		t.Error("expected an error when no smart list IDs are given")
+	}
+	if _, err := fub.New(fub.Config{SellerSmartlists: []string{"Sellers"}}); err != nil {
+		t.Errorf("smart list names alone should be enough: %v", err)
+	}
+}
+
+func TestSellerLists(t *testing.T) {
+	server := fakefub.New("key", map[int][]fub.Person{3: nil, 5: nil, 8: nil})
+	server.SmartListNames = map[int]string{5: "Past Sellers", 8: "Expired Listings"}
+	defer server.Close()
+
+	client, err := fub.New(fub.Config{
+		BaseURL:            server.URL,
+		APIKey:             "key",
+		SellerSmartlistIDs: []string{"3", "5"},
+		SellerSmartlists:   []string{"past sellers", "Expired Listings"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lists named and numbered are only returned once
+	lists, err := client.SellerLists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fub.SmartList{{3, "Smart List 3"}, {5, "Past Sellers"}, {8, "Expired Listings"}}
+	if fmt.Sprint(lists) != fmt.Sprint(want) {
+		t.Errorf("SellerLists() = %v, want %v", lists, want)
+	}
+
+	// Every missing list is named in the error
+	client, err = fub.New(fub.Config{
+		BaseURL:            server.URL,
+		APIKey:             "key",
+		SellerSmartlistIDs: []string{"4"},
+		SellerSmartlists:   []string{"Buyers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SellerLists(context.Background()); err == nil || !strings.Contains(err.Error(), `4, "Buyers"`) {
+		t.Errorf("SellerLists() error = %v, want both missing lists", err)
+	}
+}
+
+func TestGetPeoplePage(t *testing.T) {
+	people := make([]fub.Person, 0, fub.BUFFER_AMOUNT+1)
+	for id := 1; id <= fub.BUFFER_AMOUNT+1; id++ {
+		people = append(people, fub.Person{ID: id, Name: "Person " + strconv.Itoa(id)})
+	}
+	server := fakefub.New("key", map[int][]fub.Person{3: people})
+	defer server.Close()
+
+	client := newClient(t, server, "key")
+	page, next, total, err := client.GetPeoplePage(context.Background(), 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != fub.BUFFER_AMOUNT || next == "" || total != fub.BUFFER_AMOUNT+1 {
+		t.Errorf("first page: got %d people, next=%q, total=%d", len(page), next, total)
+	}
+
+	page, next, _, err = client.GetPeoplePage(context.Background(), 3, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != fub.BUFFER_AMOUNT+1 || next != "" {
+		t.Errorf("last page: got %v, next=%q", page, next)
+	}
+}
+
+func TestPeopleFollowsCursor(t *testing.T) {
+	people := make([]fub.Person, 0, 2*fub.BUFFER_AMOUNT+5)
+	for id := 1; id <= 2*fub.BUFFER_AMOUNT+5; id++ {
+		people = append(people, fub.Person{ID: id})
+	}
+	server := fakefub.New("key", map[int][]fub.Person{3: people})
+	defer server.Close()
+
+	var ids []int
+	for person, err := range newClient(t, server, "key").People(context.Background(), 3) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, person.ID)
+	}
+	if len(ids) != len(people) || ids[0] != 1 || ids[len(ids)-1] != len(people) {
+		t.Errorf("got %d people (%v...%v), want %d", len(ids), ids[0], ids[len(ids)-1], len(people))
+	}
+
+	// Breaking out early stops fetching pages
+	count := 0
+	for range newClient(t, server, "key").People(context.Background(), 3) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Errorf("early break yielded %d people", count)
+	}
+}
+
+func TestCollectPeople(t *testing.T) {
+	server := fakefub.New("key", map[int][]fub.Person{
+		3: {{ID: 1}, {ID: 2}},
+		5: {{ID: 2}, {ID: 3}, {ID: 1}},
+	})
+	defer server.Close()
+
+	lists := []fub.SmartList{{3, "Sellers"}, {5, "Expired"}}
+	people, stats, err := newClient(t, server, "key").CollectPeople(context.Background(), lists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].People != 2 || stats[1].People != 3 {
+		t.Errorf("stats = %+v, want 2 and 3 people", stats)
+	}
+
+	got := make([]string, 0, len(people))
+	for _, person := range people {
+		got = append(got, fmt.Sprintf("%d:%s", person.ID, strings.Join(person.SmartListNames(), "+")))
+	}
+	if want := "1:Sellers+Expired 2:Sellers+Expired 3:Expired"; strings.Join(got, " ") != want {
+		t.Errorf("CollectPeople() = %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePerson(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+
+	client := newClient(t, server, "key")
+	if err := client.UpdatePerson(context.Background(), 42, []string{fub.SOLD_TAG}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	puts := server.Puts()
+	if len(puts) != 1 {
+		t.Fatalf("got %d PUTs, want 1", len(puts))
+	}
+	if puts[0].PersonID != 42 || puts[0].Query.Get("mergeTags") != "true" || puts[0].Body != `{"tags":["Expired Lead"]}` {
+		t.Errorf("unexpected PUT %+v", puts[0])
+	}
+
+	// Rejected writes surface as errors
+	if err := newClient(t, server, "wrong").UpdatePerson(context.Background(), 42, []string{fub.SOLD_TAG}, ""); !errors.Is(err, fub.ErrUnauthorized) {
+		t.Errorf("got %v, want ErrUnauthorized with a bad API key", err)
+	}
+}
+
+func TestPersonIsExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		excluded []string
+		included []string
+		stage    string
+		want     bool
+	}{
+		{"excluded", []string{"Trash"}, nil, "Trash", true},
+		{"excluded ignores case", []string{"trash"}, nil, "Trash", true},
+		{"not excluded", []string{"Trash"}, nil, "Lead", false},
+		{"included", nil, []string{"Lead", "Prospect"}, "Prospect", false},
+		{"not included", nil, []string{"Lead", "Prospect"}, "Closed", true},
+		{"included and excluded", []string{"Lead"}, []string{"Lead"}, "Lead", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := fub.New(fub.Config{SellerSmartlistIDs: []string{"1"}, ExcludedStages: test.excluded, IncludedStages: test.included})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := client.PersonIsExcluded(&fub.Person{Stage: test.stage}); got != test.want {
+				t.Errorf("PersonIsExcluded(%q) = %v, want %v", test.stage, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveStages(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+
+	newStagesClient := func(excluded []string, included []string, unknown string) *fub.Client {
+		client, err := fub.New(fub.Config{
+			BaseURL:            server.URL,
+			APIKey:             "key",
+			SellerSmartlistIDs: []string{"1"},
+			ExcludedStages:     excluded,
+			IncludedStages:     included,
+			UnknownStages:      unknown,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	// Unknown stages are dropped with a warning by default
+	client := newStagesClient([]string{"TRASH", "Trsh"}, nil, "")
+	if err := client.ResolveStages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !client.PersonIsExcluded(&fub.Person{Stage: "Trash"}) || client.PersonIsExcluded(&fub.Person{Stage: "Trsh"}) {
+		t.Error("stages were not resolved against FUB")
+	}
+
+	// ...or fail the run
+	client = newStagesClient([]string{"Trsh"}, []string{"Leed"}, "fail")
+	if err := client.ResolveStages(context.Background()); err == nil || !strings.Contains(err.Error(), `"Trsh", "Leed"`) {
+		t.Errorf("ResolveStages() error = %v, want both unknown stages", err)
+	}
+
+	// An include list where nothing matches would otherwise process everyone
+	client = newStagesClient(nil, []string{"Leed"}, "warn")
+	if err := client.ResolveStages(context.Background()); err == nil {
+		t.Error("expected an error when no included stage exists")
+	}
+
+	// Stages past the first page are found too
+	server.Stages = append([]string(nil), fakefub.DefaultStages...)
+	for i := len(server.Stages); i < fub.BUFFER_AMOUNT+50; i++ {
+		server.Stages = append(server.Stages, fmt.Sprintf("Custom %d", i))
+	}
+	last := server.Stages[len(server.Stages)-1]
+	stages, err := newStagesClient(nil, nil, "").Stages(context.Background())
+	if err != nil || len(stages) != len(server.Stages) {
+		t.Fatalf("Stages() returned %d stages, %v; want %d", len(stages), err, len(server.Stages))
+	}
+	client = newStagesClient([]string{"trash"}, []string{strings.ToUpper(last)}, "fail")
+	if err := client.ResolveStages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.PersonIsExcluded(&fub.Person{Stage: last}) || !client.PersonIsExcluded(&fub.Person{Stage: "Lead"}) {
+		t.Errorf("%q on the second page was not resolved", last)
+	}
+}
@@ -0,0 +1,162 @@
+package fub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"for-sale-report/logging"
+)
+
+const (
+	JOURNAL_UPDATE = "update" // Tags and/or stage changed
+	JOURNAL_NOTE   = "note"   // Note created
+	JOURNAL_TASK   = "task"   // Task created
+)
+
+// JournalEntry records one mutation made to a person, with enough state to undo it
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	PersonID  int       `json:"personId"`
+	Action    string    `json:"action"`
+	PrevTags  []string  `json:"prevTags,omitempty"`
+	PrevStage string    `json:"prevStage,omitempty"`
+	NewTags   []string  `json:"newTags,omitempty"`
+	NewStage  string    `json:"newStage,omitempty"`
+	CreatedID int       `json:"createdId,omitempty"` // Note or task ID
+}
+
+// Journal appends every mutation made by one run to a JSON lines file
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// JournalPath is where the journal for runID lives inside dir
+func JournalPath(dir string, runID string) string {
+	return filepath.Join(dir, runID+".jsonl")
+}
+
+// OpenJournal opens (or continues) the journal for runID in dir
+func OpenJournal(dir string, runID string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(JournalPath(dir, runID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &Journal{file: file}, nil
+}
+
+// Record appends entry and syncs it to disk before returning
+func (j *Journal) Record(entry JournalEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// ReadJournal reads every entry from a journal file
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]JournalEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Rollback undoes journal entries, newest first. Tags the run added are removed and the
+// previous stage restored, leaving alone anything changed in FUB since. Created notes and
+// tasks are deleted. Every entry is attempted; failures are returned together.
+func (f *Client) Rollback(ctx context.Context, entries []JournalEntry) error {
+	var errs []error
+
+	for _, entry := range slices.Backward(entries) {
+		ctx := logging.With(ctx, logging.KEY_PERSON_ID, entry.PersonID)
+		var err error
+		switch entry.Action {
+		case JOURNAL_UPDATE:
+			err = f.rollbackUpdate(ctx, entry)
+		case JOURNAL_NOTE:
+			err = f.DeleteNote(ctx, entry.CreatedID)
+		case JOURNAL_TASK:
+			err = f.DeleteTask(ctx, entry.CreatedID)
+		default:
+			err = fmt.Errorf("%v: unknown journal action %q", entry.PersonID, entry.Action)
+		}
+
+		if errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		slog.InfoContext(ctx, "Rolled back", "action", entry.Action)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *Client) rollbackUpdate(ctx context.Context, entry JournalEntry) error {
+	current, err := f.GetPerson(ctx, entry.PersonID)
+	if err != nil {
+		return err
+	}
+
+	// Only remove tags this run added
+	tags := slices.DeleteFunc(slices.Clone(current.Tags), func(tag string) bool {
+		return slices.Contains(entry.NewTags, tag) && !slices.Contains(entry.PrevTags, tag)
+	})
+
+	stage := current.Stage
+	if entry.NewStage != entry.PrevStage {
+		if current.Stage == entry.NewStage {
+			stage = entry.PrevStage
+		} else {
+			slog.WarnContext(ctx, "Stage changed since the run, not restoring it", "stage", current.Stage, "previous_stage", entry.PrevStage)
+		}
+	}
+
+	return f.SetPerson(ctx, entry.PersonID, tags, stage)
+}
@@ -0,0 +1,95 @@
+package fub_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/fakefub"
+)
+
+func TestJournalRollback(t *testing.T) {
+	server := fakefub.New("key", map[int][]fub.Person{3: {
+		{ID: 1, Stage: "Lead", Tags: []string{"Seller"}},
+		{ID: 2, Stage: "Lead", Tags: []string{"Expired Lead"}},
+		{ID: 3, Stage: "Lead"},
+	}})
+	defer server.Close()
+	client := newClient(t, server, "key")
+
+	dir := t.TempDir()
+	journal, err := fub.OpenJournal(dir, "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := fub.NewWriteQueue(client, fastWrites, filepath.Join(dir, "pending.json"), journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.Enqueue(fub.Update{PersonID: 1, AddTags: []string{"Expired Lead"}, Notes: []string{"Sold"}, Tasks: []fub.Task{{Name: "Call"}}})
+	queue.Enqueue(fub.Update{PersonID: 2, AddTags: []string{"Expired Lead"}})
+	queue.Enqueue(fub.Update{PersonID: 3, Stage: "Closed"})
+	if _, err := queue.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	entries, err := fub.ReadJournal(fub.JournalPath(dir, "run-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("journaled %d entries, want 5", len(entries))
+	}
+	if entry := entries[0]; fmt.Sprint(entry.PrevTags, entry.NewTags) != "[Seller] [Seller Expired Lead]" {
+		t.Errorf("first entry = %+v", entry)
+	}
+
+	// Changes made in FUB after the run are kept
+	if err := client.SetPerson(context.Background(), 1, []string{"Seller", "Expired Lead", "Hot"}, "Lead"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Rollback(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]string{
+		1: "Lead [Seller Hot]",
+		2: "Lead [Expired Lead]", // Already tagged before the run
+		3: "Lead []",
+	}
+	for id, want := range want {
+		person := server.Person(id)
+		if got := fmt.Sprint(person.Stage, " ", person.Tags); got != want {
+			t.Errorf("person %d = %s, want %s", id, got, want)
+		}
+	}
+
+	deletes := 0
+	for _, write := range server.Writes() {
+		if write.Method == http.MethodDelete {
+			deletes++
+		}
+	}
+	if deletes != 2 {
+		t.Errorf("got %d deletes, want the note and the task", deletes)
+	}
+}
+
+func TestRollbackKeepsNewerStage(t *testing.T) {
+	server := fakefub.New("key", map[int][]fub.Person{3: {{ID: 1, Stage: "Active Client"}}})
+	defer server.Close()
+	client := newClient(t, server, "key")
+
+	entries := []fub.JournalEntry{{PersonID: 1, Action: fub.JOURNAL_UPDATE, PrevStage: "Lead", NewStage: "Closed"}}
+	if err := client.Rollback(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if stage := server.Person(1).Stage; stage != "Active Client" {
+		t.Errorf("stage = %q, want the stage set after the run", stage)
+	}
+}
@@ -0,0 +1,373 @@
+package fub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"for-sale-report/internal/atomicfile"
+	"for-sale-report/logging"
+	"for-sale-report/tracing"
+)
+
+const DEFAULT_WRITES_PER_SECOND = 2
+const DEFAULT_MAX_ATTEMPTS = 5
+const DEFAULT_RETRY_DELAY = 2 * time.Second
+const MAX_RETRY_DELAY = time.Minute
+
+// WriteConfig controls how queued updates are sent to FUB
+type WriteConfig struct {
+	PerSecond   float64       `toml:"per_second"`   // API calls per second, DEFAULT_WRITES_PER_SECOND when 0
+	MaxAttempts int           `toml:"max_attempts"` // Tries per call before giving up, DEFAULT_MAX_ATTEMPTS when 0
+	RetryDelay  time.Duration `toml:"retry_delay"`  // First retry delay, doubled each attempt, DEFAULT_RETRY_DELAY when 0
+}
+
+type Task struct {
+	Name    string `json:"name"`
+	DueDate string `json:"dueDate,omitempty"` // YYYY-MM-DD
+}
+
+// Update is every pending change to one person.
+// Tags and stage go out in a single PUT; notes and tasks need a call each.
+type Update struct {
+	PersonID int      `json:"personId"`
+	AddTags  []string `json:"addTags,omitempty"`
+	Stage    string   `json:"stage,omitempty"`
+	Notes    []string `json:"notes,omitempty"`
+	Tasks    []Task   `json:"tasks,omitempty"`
+}
+
+// merge folds other into u; the later stage wins
+func (u *Update) merge(other Update) {
+	for _, tag := range other.AddTags {
+		if !slices.Contains(u.AddTags, tag) {
+			u.AddTags = append(u.AddTags, tag)
+		}
+	}
+	if other.Stage != "" {
+		u.Stage = other.Stage
+	}
+	u.Notes = append(u.Notes, other.Notes...)
+	u.Tasks = append(u.Tasks, other.Tasks...)
+}
+
+func (u *Update) isEmpty() bool {
+	return len(u.AddTags) == 0 && u.Stage == "" && len(u.Notes) == 0 && len(u.Tasks) == 0
+}
+
+// WriteQueue coalesces updates per person and sends them at a bounded rate, retrying
+// retryable failures. Pending updates are saved to disk after every change so a run
+// that is interrupted picks them up again.
+type WriteQueue struct {
+	client      *Client
+	journal     *Journal
+	path        string
+	interval    time.Duration
+	maxAttempts int
+	retryDelay  time.Duration
+
+	mu       sync.Mutex
+	pending  []*Update
+	lastCall time.Time
+}
+
+// NewWriteQueue creates a queue saved at path, loading any updates left by a previous run.
+// When journal isn't nil every successful write is recorded in it so it can be rolled back.
+func NewWriteQueue(client *Client, config WriteConfig, path string, journal *Journal) (*WriteQueue, error) {
+	perSecond := config.PerSecond
+	if perSecond <= 0 {
+		perSecond = DEFAULT_WRITES_PER_SECOND
+	}
+	maxAttempts := config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+	retryDelay := config.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = DEFAULT_RETRY_DELAY
+	}
+
+	q := &WriteQueue{
+		client:      client,
+		journal:     journal,
+		path:        path,
+		interval:    time.Duration(float64(time.Second) / perSecond),
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending writes: %w", err)
+	}
+	if err := json.Unmarshal(data, &q.pending); err != nil {
+		return nil, fmt.Errorf("failed to parse pending writes %s: %w", path, err)
+	}
+	if len(q.pending) > 0 {
+		slog.Info("Resuming pending writes", "count", len(q.pending), "path", path)
+	}
+
+	return q, nil
+}
+
+// Len returns the number of people with pending updates
+func (q *WriteQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Enqueue adds an update, merging it with any pending update for the same person
+func (q *WriteQueue) Enqueue(update Update) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	index := slices.IndexFunc(q.pending, func(pending *Update) bool { return pending.PersonID == update.PersonID })
+	if index == -1 {
+		q.pending = append(q.pending, &Update{PersonID: update.PersonID})
+		index = len(q.pending) - 1
+	}
+	q.pending[index].merge(update)
+
+	return q.save()
+}
+
+// localError is a failure on our side, such as saving state or journaling, which stops a flush
+type localError struct {
+	error
+}
+
+func (e localError) Unwrap() error {
+	return e.error
+}
+
+// Flush sends every pending update. Updates that still fail with a retryable error once
+// their attempts run out, such as during a FUB outage, stay queued for the next flush; other
+// failures are dropped. Both are returned by person ID, and IsRetryable tells them apart.
+// A fatal error, such as a bad API key, a cancelled context or a failure to save state,
+// stops the flush and leaves the remaining updates saved for the next run.
+func (q *WriteQueue) Flush(ctx context.Context) (failed map[int]error, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failed = make(map[int]error)
+	for i := 0; i < len(q.pending); {
+		update := q.pending[i]
+		ctx := logging.With(ctx, logging.KEY_PERSON_ID, update.PersonID)
+
+		err := q.apply(ctx, update)
+		var local localError
+		if err != nil && (errors.Is(err, ErrUnauthorized) || ctx.Err() != nil || errors.As(err, &local)) {
+			return failed, err
+		}
+		if err != nil {
+			failed[update.PersonID] = err
+		}
+		if IsRetryable(err) {
+			slog.WarnContext(ctx, "Keeping update for the next run after error", "error", err)
+			i++
+			continue
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Dropping update after error", "error", err)
+		}
+
+		q.pending = slices.Delete(q.pending, i, i+1)
+		if err := q.save(); err != nil {
+			return failed, err
+		}
+	}
+
+	return failed, nil
+}
+
+// apply sends one update, saving progress after each call so nothing is sent twice on resume
+func (q *WriteQueue) apply(ctx context.Context, update *Update) (err error) {
+	ctx, span := tracer.Start(ctx, "fub.write", trace.WithAttributes(
+		attribute.Int(logging.KEY_PERSON_ID, update.PersonID),
+		attribute.StringSlice("add_tags", update.AddTags),
+		attribute.String("stage", update.Stage),
+		attribute.Int("notes", len(update.Notes)),
+		attribute.Int("tasks", len(update.Tasks)),
+	))
+	defer tracing.End(span, &err)
+
+	if len(update.AddTags) > 0 || update.Stage != "" {
+		// The journal needs the state from before the change
+		var before *Person
+		if q.journal != nil {
+			err := q.call(ctx, func() (err error) {
+				before, err = q.client.GetPerson(ctx, update.PersonID)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := q.call(ctx, func() error {
+			return q.client.UpdatePerson(ctx, update.PersonID, update.AddTags, update.Stage)
+		})
+		if err != nil {
+			return err
+		}
+
+		if before != nil {
+			entry := JournalEntry{
+				PersonID:  update.PersonID,
+				Action:    JOURNAL_UPDATE,
+				PrevTags:  before.Tags,
+				PrevStage: before.Stage,
+				NewTags:   slices.Clone(before.Tags),
+				NewStage:  before.Stage,
+			}
+			for _, tag := range update.AddTags {
+				if !slices.Contains(entry.NewTags, tag) {
+					entry.NewTags = append(entry.NewTags, tag)
+				}
+			}
+			if update.Stage != "" {
+				entry.NewStage = update.Stage
+			}
+			if err := q.record(entry); err != nil {
+				return err
+			}
+		}
+
+		update.AddTags, update.Stage = nil, ""
+		if err := q.save(); err != nil {
+			return localError{err}
+		}
+	}
+
+	for len(update.Notes) > 0 {
+		var id int
+		err := q.call(ctx, func() (err error) {
+			id, err = q.client.AddNote(ctx, update.PersonID, update.Notes[0])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.record(JournalEntry{PersonID: update.PersonID, Action: JOURNAL_NOTE, CreatedID: id}); err != nil {
+			return err
+		}
+		update.Notes = update.Notes[1:]
+		if err := q.save(); err != nil {
+			return localError{err}
+		}
+	}
+
+	for len(update.Tasks) > 0 {
+		var id int
+		err := q.call(ctx, func() (err error) {
+			id, err = q.client.AddTask(ctx, update.PersonID, update.Tasks[0])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.record(JournalEntry{PersonID: update.PersonID, Action: JOURNAL_TASK, CreatedID: id}); err != nil {
+			return err
+		}
+		update.Tasks = update.Tasks[1:]
+		if err := q.save(); err != nil {
+			return localError{err}
+		}
+	}
+
+	return nil
+}
+
+// record adds entry to the journal, if there is one
+func (q *WriteQueue) record(entry JournalEntry) error {
+	if q.journal == nil {
+		return nil
+	}
+	if err := q.journal.Record(entry); err != nil {
+		return localError{err}
+	}
+	return nil
+}
+
+// call runs fn at the configured rate, retrying retryable errors with exponential backoff
+func (q *WriteQueue) call(ctx context.Context, fn func() error) error {
+	delay := q.retryDelay
+
+	for attempt := 1; ; attempt++ {
+		if err := sleep(ctx, time.Until(q.lastCall.Add(q.interval))); err != nil {
+			return err
+		}
+		q.lastCall = time.Now()
+
+		err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= q.maxAttempts {
+			return err
+		}
+
+		// Respect the server's Retry-After when it asks for longer
+		wait := delay
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		slog.WarnContext(ctx, "FUB call failed, retrying", "attempt", attempt, "max_attempts", q.maxAttempts, "retry_in", wait, "error", err)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		delay = min(delay*2, MAX_RETRY_DELAY)
+	}
+}
+
+// save writes the pending updates to disk, removing the file when there are none
+func (q *WriteQueue) save() error {
+	pending := slices.DeleteFunc(slices.Clone(q.pending), (*Update).isEmpty)
+	if len(pending) == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove pending writes: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(q.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save pending writes: %w", err)
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
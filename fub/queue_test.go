@@ -0,0 +1,189 @@
+package fub_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/fakefub"
+)
+
+var fastWrites = fub.WriteConfig{PerSecond: 1000, MaxAttempts: 3, RetryDelay: time.Millisecond}
+
+func newQueue(t *testing.T, server *fakefub.Server, path string) *fub.WriteQueue {
+	t.Helper()
+	queue, err := fub.NewWriteQueue(newClient(t, server, "key"), fastWrites, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+func writeSummary(writes []fakefub.Write) string {
+	lines := make([]string, 0, len(writes))
+	for _, write := range writes {
+		lines = append(lines, write.Method+" "+write.Path+" "+write.Body)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestWriteQueueCoalesces(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+
+	queue := newQueue(t, server, filepath.Join(t.TempDir(), "pending.json"))
+	updates := []fub.Update{
+		{PersonID: 1, AddTags: []string{"Expired Lead"}},
+		{PersonID: 2, Stage: "Lead"},
+		{PersonID: 1, AddTags: []string{"Expired Lead", "Sold"}, Stage: "Past Client"},
+		{PersonID: 1, Notes: []string{"Sold on 01/02/2025"}, Tasks: []fub.Task{{Name: "Call", DueDate: "2025-01-03"}}},
+	}
+	for _, update := range updates {
+		if err := queue.Enqueue(update); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if queue.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", queue.Len())
+	}
+
+	failed, err := queue.Flush(context.Background())
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Flush() = %v, %v", failed, err)
+	}
+
+	want := strings.Join([]string{
+		`PUT /v1/people/1 {"stage":"Past Client","tags":["Expired Lead","Sold"]}`,
+		`POST /v1/notes {"body":"Sold on 01/02/2025","personId":1,"subject":"ForSaleReport"}`,
+		`POST /v1/tasks {"dueDate":"2025-01-03","name":"Call","personId":1}`,
+		`PUT /v1/people/2 {"stage":"Lead"}`,
+	}, "\n")
+	if got := writeSummary(server.Writes()); got != want {
+		t.Errorf("writes:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteQueueRetries(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+
+	// Retryable errors are retried, anything else drops that person's update
+	server.FailWrites(400, 503, 429)
+	queue := newQueue(t, server, filepath.Join(t.TempDir(), "pending.json"))
+	queue.Enqueue(fub.Update{PersonID: 1, AddTags: []string{"Expired Lead"}})
+	queue.Enqueue(fub.Update{PersonID: 2, AddTags: []string{"Expired Lead"}})
+	queue.Enqueue(fub.Update{PersonID: 3, AddTags: []string{"Expired Lead"}})
+
+	failed, err := queue.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[1] == nil {
+		t.Errorf("failed = %v, want only person 1", failed)
+	}
+
+	puts := server.Puts()
+	if len(puts) != 2 || puts[0].PersonID != 2 || puts[1].PersonID != 3 {
+		t.Errorf("puts = %v, want people 2 and 3", puts)
+	}
+}
+
+func TestWriteQueueKeepsRetryableFailures(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "pending.json")
+
+	// FUB is down for every attempt at person 1, and person 2's update is rejected
+	server.FailWrites(503, 503, 503, 400)
+	queue := newQueue(t, server, path)
+	queue.Enqueue(fub.Update{PersonID: 1, AddTags: []string{"Expired Lead"}, Notes: []string{"Sold"}})
+	queue.Enqueue(fub.Update{PersonID: 2, AddTags: []string{"Expired Lead"}})
+
+	failed, err := queue.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 || !fub.IsRetryable(failed[1]) || fub.IsRetryable(failed[2]) {
+		t.Errorf("failed = %v, want a retryable error for person 1 and not for person 2", failed)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("Len() = %d, want person 1 still queued", queue.Len())
+	}
+
+	// The next run sends it in full
+	queue = newQueue(t, server, path)
+	if queue.Len() != 1 {
+		t.Fatalf("resumed %d updates, want 1", queue.Len())
+	}
+	if failed, err := queue.Flush(context.Background()); err != nil || len(failed) != 0 {
+		t.Fatalf("Flush() = %v, %v", failed, err)
+	}
+	want := strings.Join([]string{
+		`PUT /v1/people/1 {"tags":["Expired Lead"]}`,
+		`POST /v1/notes {"body":"Sold","personId":1,"subject":"ForSaleReport"}`,
+	}, "\n")
+	if got := writeSummary(server.Writes()); got != want {
+		t.Errorf("writes:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteQueueResumes(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "pending.json")
+
+	// A bad API key stops the flush with everything still saved
+	server.FailWrites(401)
+	queue := newQueue(t, server, path)
+	queue.Enqueue(fub.Update{PersonID: 1, AddTags: []string{"Expired Lead"}})
+	queue.Enqueue(fub.Update{PersonID: 2, AddTags: []string{"Expired Lead"}})
+
+	if _, err := queue.Flush(context.Background()); !errors.Is(err, fub.ErrUnauthorized) {
+		t.Fatalf("Flush() error = %v, want ErrUnauthorized", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("pending writes were not kept: %v", err)
+	}
+
+	// The next run picks them up and clears the file once they are sent
+	queue = newQueue(t, server, path)
+	if queue.Len() != 2 {
+		t.Fatalf("resumed %d updates, want 2", queue.Len())
+	}
+	if _, err := queue.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Puts()) != 2 {
+		t.Errorf("got %d PUTs after resuming, want 2", len(server.Puts()))
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pending writes file still exists: %v", err)
+	}
+}
+
+func TestWriteQueueRateLimit(t *testing.T) {
+	server := fakefub.New("key", nil)
+	defer server.Close()
+
+	queue, err := fub.NewWriteQueue(newClient(t, server, "key"), fub.WriteConfig{PerSecond: 20}, filepath.Join(t.TempDir(), "pending.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 5; id++ {
+		queue.Enqueue(fub.Update{PersonID: id, Stage: "Lead"})
+	}
+
+	start := time.Now()
+	if _, err := queue.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 5 calls at 20/s need at least 4 gaps of 50ms
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 writes took %v, want at least 200ms", elapsed)
+	}
+}
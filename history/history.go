@@ -0,0 +1,283 @@
+// Package history records what each run checked so past runs can be browsed
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/atomicfile"
+	"for-sale-report/mls"
+)
+
+// Outcome of checking one person
+const (
+	RESULT_SOLD     = "sold"     // Sold since the reference date
+	RESULT_NOT_SOLD = "not sold" // Found in the MLS, not sold since
+	RESULT_SKIPPED  = "skipped"  // Not looked up, see Check.Error
+	RESULT_ERROR    = "error"    // The lookup failed
+)
+
+// SmartList is the time spent on one smart list during a run.
+// People found in several lists count towards the first one.
+type SmartList struct {
+	ID     int           `json:"id"`
+	Name   string        `json:"name"`
+	People int           `json:"people"`
+	Fetch  time.Duration `json:"fetch"` // Paging through the list in FUB
+	Check  time.Duration `json:"check"` // Looking up its people in the MLS
+}
+
+// Total is the time spent fetching and checking the list
+func (s SmartList) Total() time.Duration {
+	return s.Fetch + s.Check
+}
+
+// Check is the outcome of looking up one person
+type Check struct {
+	Time       time.Time       `json:"time"`
+	PersonID   int             `json:"personId"`
+	Name       string          `json:"name"`
+	Stage      string          `json:"stage"`
+	Address    string          `json:"address,omitempty"`
+	SmartLists []fub.SmartList `json:"smartLists,omitempty"`
+	Since      time.Time       `json:"since"` // Sales after this count as sold
+	Result     string          `json:"result"`
+	Match      *mls.Match      `json:"match,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Duration   time.Duration   `json:"duration"`
+}
+
+// Run is everything one run of one tenant did
+type Run struct {
+	ID         string      `json:"id"`
+	Tenant     string      `json:"tenant"`
+	Mode       string      `json:"mode"`
+	Started    time.Time   `json:"started"`
+	Finished   time.Time   `json:"finished"`
+	Error      string      `json:"error,omitempty"`
+	SmartLists []SmartList `json:"smartLists"`
+	Checks     []Check     `json:"checks"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// Start begins recording a run, saved to dir when it finishes
+func Start(dir string, tenant string, runID string, mode string) *Run {
+	return &Run{
+		ID:         runID,
+		Tenant:     tenant,
+		Mode:       mode,
+		Started:    time.Now(),
+		SmartLists: make([]SmartList, 0),
+		Checks:     make([]Check, 0),
+		path:       filepath.Join(dir, runID+".json"),
+	}
+}
+
+// AddSmartLists records the smart lists fetched for the run
+func (r *Run) AddSmartLists(stats []fub.SmartListStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, list := range stats {
+		r.SmartLists = append(r.SmartLists, SmartList{ID: list.ID, Name: list.Name, People: list.People, Fetch: list.Duration})
+	}
+}
+
+// AddCheck records one person, adding its duration to the first smart list they came from
+func (r *Run) AddCheck(check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if check.Time.IsZero() {
+		check.Time = time.Now()
+	}
+	if len(check.SmartLists) > 0 {
+		index := slices.IndexFunc(r.SmartLists, func(list SmartList) bool { return list.ID == check.SmartLists[0].ID })
+		if index != -1 {
+			r.SmartLists[index].Check += check.Duration
+		}
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// Finish marks the run done, with the error that stopped it if any, and saves it
+func (r *Run) Finish(runErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now()
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(r.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save run history: %w", err)
+	}
+	return nil
+}
+
+// Duration is how long the run took
+func (r *Run) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// Count returns how many checks had the given result
+func (r *Run) Count(result string) int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Result == result {
+			count++
+		}
+	}
+	return count
+}
+
+// Run IDs are file names in the runs directory, so they can't contain separators or dots
+var runIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Load reads the run runID from dir. IDs that couldn't name a run, like "../x", are reported as not existing.
+func Load(dir string, runID string) (*Run, error) {
+	if !runIDRe.MatchString(runID) {
+		return nil, fmt.Errorf("invalid run ID %q: %w", runID, os.ErrNotExist)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, runID+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("run %s: %w", runID, err)
+	}
+	return &run, nil
+}
+
+// Runs reads every run saved in dir, newest first.
+// Files that can't be read, such as one cut short by a crash, are logged and skipped.
+func Runs(dir string) ([]*Run, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*Run, 0, len(entries))
+	for _, entry := range entries {
+		runID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		run, err := Load(dir, runID)
+		if err != nil {
+			slog.Warn("Skipping unreadable run history", "path", filepath.Join(dir, entry.Name()), "error", err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	slices.SortFunc(runs, func(a, b *Run) int { return b.Started.Compare(a.Started) })
+	return runs, nil
+}
+
+// PersonCheck is one check of a person, with the run it was part of
+type PersonCheck struct {
+	RunID string
+	Check
+}
+
+// PersonHistory returns every check of personID across runs, in the order of runs
+func PersonHistory(runs []*Run, personID int) []PersonCheck {
+	checks := make([]PersonCheck, 0)
+	for _, run := range runs {
+		for _, check := range run.Checks {
+			if check.PersonID == personID {
+				checks = append(checks, PersonCheck{run.ID, check})
+			}
+		}
+	}
+	return checks
+}
+
+// Complete reports whether the run checked every smart list without failing.
+// Rechecks of a single person and failed runs are not complete.
+func (r *Run) Complete() bool {
+	return r.Error == "" && !r.Finished.IsZero() && len(r.SmartLists) > 0
+}
+
+// LastComplete returns the most recent complete run from runs, newest first as Runs returns them,
+// or nil when there is none
+func LastComplete(runs []*Run) *Run {
+	for _, run := range runs {
+		if run.Complete() {
+			return run
+		}
+	}
+	return nil
+}
+
+// Membership is when a person was first seen, by a run that is still unbroken, in their stage
+// and in each of their smart lists
+type Membership struct {
+	Stage      string
+	StageSince time.Time
+	SmartLists map[int]time.Time // By smart list ID
+}
+
+// Memberships works out the Membership of everyone seen by the complete runs in runs,
+// newest first as Runs returns them. A person missing from a complete run has left every smart list.
+func Memberships(runs []*Run) map[int]*Membership {
+	memberships := make(map[int]*Membership)
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if !run.Complete() {
+			continue
+		}
+
+		seen := make(map[int]bool, len(run.Checks))
+		for _, check := range run.Checks {
+			seen[check.PersonID] = true
+			m := memberships[check.PersonID]
+			if m == nil {
+				m = &Membership{}
+				memberships[check.PersonID] = m
+			}
+			if m.StageSince.IsZero() || m.Stage != check.Stage {
+				m.Stage, m.StageSince = check.Stage, run.Started
+			}
+
+			lists := make(map[int]time.Time, len(check.SmartLists))
+			for _, list := range check.SmartLists {
+				if since, ok := m.SmartLists[list.ID]; ok {
+					lists[list.ID] = since
+				} else {
+					lists[list.ID] = run.Started
+				}
+			}
+			m.SmartLists = lists
+		}
+
+		for id, m := range memberships {
+			if !seen[id] {
+				m.SmartLists = nil
+			}
+		}
+	}
+	return memberships
+}
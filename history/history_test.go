@@ -0,0 +1,151 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"for-sale-report/fub"
+	"for-sale-report/mls"
+)
+
+func TestRunRecordsAndLoads(t *testing.T) {
+	dir := t.TempDir()
+	lists := []fub.SmartList{{ID: 3, Name: "Sellers"}, {ID: 5, Name: "Expired"}}
+
+	run := Start(dir, "north", "run-1", "tag")
+	run.AddSmartLists([]fub.SmartListStats{{SmartList: lists[0], People: 2, Duration: time.Second}, {SmartList: lists[1], People: 1}})
+	run.AddCheck(Check{PersonID: 1, SmartLists: lists, Result: RESULT_SOLD, Match: &mls.Match{MlsID: "M1"}, Duration: 2 * time.Second})
+	run.AddCheck(Check{PersonID: 2, SmartLists: lists[1:], Result: RESULT_ERROR, Error: "timeout", Duration: time.Second})
+	if err := run.Finish(errors.New("SMTP down")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run of the same person
+	later := Start(dir, "north", "run-2", "tag")
+	later.Started = run.Started.Add(time.Hour)
+	later.AddCheck(Check{PersonID: 1, Result: RESULT_NOT_SOLD})
+	if err := later.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := Runs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "run-2" {
+		t.Fatalf("Runs() returned %d runs, want run-2 first", len(runs))
+	}
+
+	loaded := runs[1]
+	if loaded.Error != "SMTP down" || loaded.Count(RESULT_SOLD) != 1 || loaded.Count(RESULT_ERROR) != 1 {
+		t.Errorf("loaded run = %+v", loaded)
+	}
+	if loaded.Checks[0].Match == nil || loaded.Checks[0].Match.MlsID != "M1" {
+		t.Errorf("match details were not saved: %+v", loaded.Checks[0])
+	}
+
+	// Checks count towards the first list each person came from
+	if got := loaded.SmartLists[0]; got.Check != 2*time.Second || got.Total() != 3*time.Second {
+		t.Errorf("smart list 3 = %+v", got)
+	}
+	if got := loaded.SmartLists[1]; got.Check != time.Second {
+		t.Errorf("smart list 5 = %+v", got)
+	}
+
+	checks := PersonHistory(runs, 1)
+	if len(checks) != 2 || checks[0].RunID != "run-2" || checks[1].Result != RESULT_SOLD {
+		t.Errorf("PersonHistory(1) = %+v", checks)
+	}
+}
+
+func TestRunsWithoutHistory(t *testing.T) {
+	runs, err := Runs(t.TempDir() + "/missing")
+	if err != nil || len(runs) != 0 {
+		t.Errorf("Runs() = %v, %v, want nothing", runs, err)
+	}
+}
+
+func TestLoadRejectsPaths(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "runs")
+	if err := Start(parent, "north", "secret", "tag").Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, runID := range []string{"../secret", "..", "", "run.1"} {
+		if run, err := Load(dir, runID); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load(%q) = %v, %v, want not exist", runID, run, err)
+		}
+	}
+}
+
+func TestRunsSkipsUnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := Start(dir, "north", "run-1", "tag").Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run-2.json"), []byte(`{"id": "run-2", "checks": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := Runs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != "run-1" {
+		t.Errorf("Runs() = %v, want only run-1", runs)
+	}
+}
+
+func TestMemberships(t *testing.T) {
+	start := time.Date(2025, 1, 1, 6, 0, 0, 0, time.UTC)
+	sellers, expired := fub.SmartList{ID: 3, Name: "Sellers"}, fub.SmartList{ID: 5, Name: "Expired"}
+	day := func(n int) time.Time { return start.AddDate(0, 0, n) }
+
+	// Built oldest first, then reversed as Runs returns them
+	runs := []*Run{
+		{ID: "1", Started: day(0), Finished: day(0), SmartLists: []SmartList{{ID: 3}}, Checks: []Check{
+			{PersonID: 1, Stage: "Lead", SmartLists: []fub.SmartList{sellers}},
+			{PersonID: 2, Stage: "Lead", SmartLists: []fub.SmartList{sellers}},
+		}},
+		{ID: "2", Started: day(1), Finished: day(1), SmartLists: []SmartList{{ID: 3}}, Checks: []Check{
+			{PersonID: 1, Stage: "Lead", SmartLists: []fub.SmartList{sellers, expired}},
+			{PersonID: 2, Stage: "Past Client", SmartLists: []fub.SmartList{sellers}},
+		}},
+		// Failed and single person runs are ignored
+		{ID: "3", Started: day(2), Finished: day(2), Error: "SMTP down", SmartLists: []SmartList{{ID: 3}}},
+		{ID: "4", Started: day(3), Finished: day(3), Checks: []Check{{PersonID: 2, Stage: "Lead"}}},
+		{ID: "5", Started: day(4), Finished: day(4), SmartLists: []SmartList{{ID: 3}}, Checks: []Check{
+			{PersonID: 1, Stage: "Lead", SmartLists: []fub.SmartList{expired}},
+		}},
+	}
+	slices.Reverse(runs)
+
+	memberships := Memberships(runs)
+	one := memberships[1]
+	if one.Stage != "Lead" || !one.StageSince.Equal(day(0)) {
+		t.Errorf("person 1 stage = %s since %v, want Lead since day 0", one.Stage, one.StageSince)
+	}
+	if len(one.SmartLists) != 1 || !one.SmartLists[5].Equal(day(1)) {
+		t.Errorf("person 1 smart lists = %v, want only Expired since day 1", one.SmartLists)
+	}
+
+	two := memberships[2]
+	if two.Stage != "Past Client" || !two.StageSince.Equal(day(1)) {
+		t.Errorf("person 2 stage = %s since %v, want Past Client since day 1", two.Stage, two.StageSince)
+	}
+	if len(two.SmartLists) != 0 {
+		t.Errorf("person 2 left every list in run 5, got %v", two.SmartLists)
+	}
+
+	if last := LastComplete(runs); last == nil || last.ID != "5" {
+		t.Errorf("LastComplete = %v, want run 5", last)
+	}
+	if last := LastComplete(runs[1:]); last == nil || last.ID != "2" {
+		t.Errorf("LastComplete without run 5 = %v, want run 2", last)
+	}
+}
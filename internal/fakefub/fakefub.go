@@ -0,0 +1,327 @@
+// Package fakefub is an in-process stand-in for the Follow Up Boss API used by tests
+package fakefub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"for-sale-report/fub"
+)
+
+// Write is a recorded PUT /v1/people/{id}, POST or DELETE of a note or task
+type Write struct {
+	Method   string
+	Path     string
+	PersonID int
+	Query    url.Values
+	Body     string
+}
+
+// DefaultStages are the stages a new FUB account starts with
+var DefaultStages = []string{"Lead", "Prospect", "Active Client", "Under Contract", "Closed", "Past Client", "Trash"}
+
+// Server serves smart lists of people and records every update made to them
+type Server struct {
+	*httptest.Server
+
+	APIKey         string         // Requests with a different key get a 401
+	SmartListNames map[int]string // Defaults to "Smart List <id>"
+	Stages         []string       // Defaults to DefaultStages
+
+	mu         sync.Mutex
+	lists      map[int][]int      // Person IDs in each smart list
+	people     map[int]fub.Person // Current state of every person, updated by PUTs
+	created    map[string]int     // Person ID of each note and task, keyed by path
+	nextID     int
+	writes     []Write
+	failWrites []int // Statuses for the next writes to fail with
+}
+
+// New starts a server holding the given smart lists, keyed by smart list ID
+func New(apiKey string, lists map[int][]fub.Person) *Server {
+	s := &Server{
+		APIKey:  apiKey,
+		lists:   make(map[int][]int, len(lists)),
+		people:  make(map[int]fub.Person),
+		created: make(map[string]int),
+		nextID:  1000,
+	}
+	for listID, people := range lists {
+		ids := make([]int, 0, len(people))
+		for _, person := range people {
+			ids = append(ids, person.ID)
+			if _, ok := s.people[person.ID]; !ok {
+				s.people[person.ID] = person
+			}
+		}
+		s.lists[listID] = ids
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Writes returns every successful write received so far
+func (s *Server) Writes() []Write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Write(nil), s.writes...)
+}
+
+// Puts returns every successful PUT received so far
+func (s *Server) Puts() []Write {
+	puts := make([]Write, 0)
+	for _, write := range s.Writes() {
+		if write.Method == http.MethodPut {
+			puts = append(puts, write)
+		}
+	}
+	return puts
+}
+
+// Person returns the current state of a person, after any updates
+func (s *Server) Person(id int) fub.Person {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.people[id]
+}
+
+// FailWrites makes the next writes fail, one for each status given
+func (s *Server) FailWrites(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failWrites = append(s.failWrites, statuses...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := r.BasicAuth()
+	if !ok || user != s.APIKey || r.Header.Get("X-System") != fub.SYSTEM_HEADER {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"errorMessage": "Invalid API key"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/people":
+		s.handlePeople(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/stages":
+		s.handleStages(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/smartLists":
+		s.handleSmartLists(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/people/"):
+		s.handlePerson(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/people/"):
+		s.handleWrite(w, r)
+	case r.Method == http.MethodPost && (r.URL.Path == "/v1/notes" || r.URL.Path == "/v1/tasks"):
+		s.handleWrite(w, r)
+	case r.Method == http.MethodDelete && (strings.HasPrefix(r.URL.Path, "/v1/notes/") || strings.HasPrefix(r.URL.Path, "/v1/tasks/")):
+		s.handleWrite(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"errorMessage": "Not found"})
+	}
+}
+
+// page works out which of total results to return for the request's limit and next cursor.
+// It writes a 400 and returns false when the request pages the wrong way.
+func (s *Server) page(w http.ResponseWriter, r *http.Request, total int) (start, end int, metadata fub.Metadata, ok bool) {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	// Like FUB, only cursors are accepted for paging
+	if query.Has("offset") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": "Use next instead of offset"})
+		return 0, 0, metadata, false
+	}
+	if cursor := query.Get("next"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			start, err = strconv.Atoi(string(decoded))
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": "Invalid next cursor"})
+			return 0, 0, metadata, false
+		}
+	}
+
+	start = min(start, total)
+	end = min(start+limit, total)
+
+	metadata = fub.Metadata{Total: total}
+	if end < total {
+		cursor := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+		query.Set("next", cursor)
+		nextLink := s.URL + r.URL.Path + "?" + query.Encode()
+		metadata.Next, metadata.NextLink = &cursor, &nextLink
+	}
+	return start, end, metadata, true
+}
+
+func (s *Server) handlePeople(w http.ResponseWriter, r *http.Request) {
+	listID, _ := strconv.Atoi(r.URL.Query().Get("smartListId"))
+
+	s.mu.Lock()
+	people := make([]fub.Person, 0, len(s.lists[listID]))
+	for _, id := range s.lists[listID] {
+		people = append(people, s.people[id])
+	}
+	s.mu.Unlock()
+
+	start, end, metadata, ok := s.page(w, r, len(people))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, fub.PeopleResponse{
+		Metadata: metadata,
+		People:   people[start:end],
+	})
+}
+
+func (s *Server) handleStages(w http.ResponseWriter, r *http.Request) {
+	names := s.Stages
+	if names == nil {
+		names = DefaultStages
+	}
+
+	stages := make([]fub.Stage, 0, len(names))
+	for i, name := range names {
+		stages = append(stages, fub.Stage{ID: i + 1, Name: name})
+	}
+	start, end, metadata, ok := s.page(w, r, len(stages))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, fub.StagesResponse{
+		Metadata: metadata,
+		Stages:   stages[start:end],
+	})
+}
+
+func (s *Server) handleSmartLists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	smartLists := make([]fub.SmartList, 0, len(s.lists))
+	for id := range s.lists {
+		name, ok := s.SmartListNames[id]
+		if !ok {
+			name = "Smart List " + strconv.Itoa(id)
+		}
+		smartLists = append(smartLists, fub.SmartList{ID: id, Name: name})
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(smartLists, func(a, b fub.SmartList) int { return a.ID - b.ID })
+	start, end, metadata, ok := s.page(w, r, len(smartLists))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, fub.SmartListsResponse{
+		Metadata:   metadata,
+		SmartLists: smartLists[start:end],
+	})
+}
+
+func (s *Server) handlePerson(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v1/people/"))
+
+	s.mu.Lock()
+	person, ok := s.people[id]
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"errorMessage": "Person not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, person)
+}
+
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	// People are addressed by path for PUTs, by body for POSTs and by the note or task for DELETEs
+	var id int
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		id, err = strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v1/people/"))
+	case http.MethodPost:
+		var payload struct {
+			PersonID int `json:"personId"`
+		}
+		err = json.Unmarshal(body, &payload)
+		id = payload.PersonID
+	case http.MethodDelete:
+		s.mu.Lock()
+		id = s.created[r.URL.Path]
+		s.mu.Unlock()
+		if id == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"errorMessage": "Not found"})
+			return
+		}
+	}
+	if err != nil || id == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": "Invalid person"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.failWrites) > 0 {
+		status := s.failWrites[0]
+		s.failWrites = s.failWrites[1:]
+		writeJSON(w, status, map[string]string{"errorMessage": http.StatusText(status)})
+		return
+	}
+	s.writes = append(s.writes, Write{r.Method, r.URL.Path, id, r.URL.Query(), string(body)})
+
+	switch r.Method {
+	case http.MethodPut:
+		s.updatePerson(id, r.URL.Query().Get("mergeTags") == "true", body)
+		writeJSON(w, http.StatusOK, s.people[id])
+	case http.MethodPost:
+		s.nextID++
+		s.created[r.URL.Path+"/"+strconv.Itoa(s.nextID)] = id
+		writeJSON(w, http.StatusCreated, map[string]int{"id": s.nextID, "personId": id})
+	case http.MethodDelete:
+		delete(s.created, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// updatePerson applies a PUT body to the stored person; callers hold s.mu
+func (s *Server) updatePerson(id int, mergeTags bool, body []byte) {
+	var payload struct {
+		Tags  *[]string `json:"tags"`
+		Stage string    `json:"stage"`
+	}
+	json.Unmarshal(body, &payload)
+
+	person := s.people[id]
+	person.ID = id
+	if payload.Tags != nil {
+		if mergeTags {
+			for _, tag := range *payload.Tags {
+				if !slices.Contains(person.Tags, tag) {
+					person.Tags = append(person.Tags, tag)
+				}
+			}
+		} else {
+			person.Tags = *payload.Tags
+		}
+	}
+	if payload.Stage != "" {
+		person.Stage = payload.Stage
+	}
+	s.people[id] = person
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,211 @@
+// Package fakesmtp is an in-process SMTP server with STARTTLS and AUTH PLAIN used by tests
+package fakesmtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a captured email
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server accepts mail from one user and keeps every message in memory
+type Server struct {
+	User string
+	Pass string
+
+	listener  net.Listener
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// New starts a server on 127.0.0.1 with a freshly generated self-signed certificate
+func New(user string, pass string) (*Server, error) {
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		User:      user,
+		Pass:      pass,
+		listener:  listener,
+		serverTLS: &tls.Config{Certificates: []tls.Certificate{cert}},
+		clientTLS: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Host and Port of the listener, as strings for report.Config
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+func (s *Server) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+// ClientTLSConfig trusts the server's certificate
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return s.clientTLS.Clone()
+}
+
+// Messages returns every message received so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	text := textproto.NewConn(conn)
+	isTLS, isAuthed := false, false
+	var msg Message
+
+	text.PrintfLine("220 fakesmtp ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if isTLS {
+				text.PrintfLine("250-fakesmtp\r\n250 AUTH PLAIN")
+			} else {
+				text.PrintfLine("250-fakesmtp\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.serverTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			isTLS = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if !isTLS || mechanism != "PLAIN" || err != nil || string(decoded) != "\x00"+s.User+"\x00"+s.Pass {
+				text.PrintfLine("535 Authentication failed")
+				continue
+			}
+			isAuthed = true
+			text.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			if !isAuthed {
+				text.PrintfLine("530 Authentication required")
+				continue
+			}
+			msg = Message{From: trimPath(arg, "FROM:")}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, trimPath(arg, "TO:"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// trimPath turns "FROM:<a@b.c> BODY=8BITMIME" into "a@b.c"
+func trimPath(arg string, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg, _, _ = strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.Trim(arg, "<>")
+}
+
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fakesmtp"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool, nil
+}
@@ -0,0 +1,106 @@
+// Package logging sets up structured logging with log/slog and carries
+// correlation attributes, such as the run and person being processed, in a context
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys shared by every record, so one run, list or person can be followed through the logs
+const (
+	KEY_RUN_ID        = "run_id"
+	KEY_TENANT        = "tenant"
+	KEY_SMART_LIST_ID = "smart_list_id"
+	KEY_PERSON_ID     = "person_id"
+	KEY_MLS_ID        = "mls_id"
+	KEY_PHASE         = "phase"
+)
+
+// Values of KEY_PHASE
+const (
+	PHASE_SETUP    = "setup"    // Connecting to FUB and the MLS
+	PHASE_FETCH    = "fetch"    // Paging through smart lists
+	PHASE_CHECK    = "check"    // Looking people up in the MLS
+	PHASE_WRITE    = "write"    // Sending updates to FUB
+	PHASE_REPORT   = "report"   // Emailing the report
+	PHASE_ROLLBACK = "rollback" // Undoing a run
+	PHASE_REVIEW   = "review"   // Approving or rejecting held changes
+)
+
+const DEFAULT_FORMAT = "text"
+const DEFAULT_LEVEL = "info"
+
+// Config represents logging-related configuration
+type Config struct {
+	Format string `toml:"format"` // "text" or "json", DEFAULT_FORMAT when empty
+	Level  string `toml:"level"`  // "debug", "info", "warn" or "error", DEFAULT_LEVEL when empty
+}
+
+// ParseLevel converts a configured level name to a slog.Level
+func ParseLevel(level string) (slog.Level, error) {
+	if level == "" {
+		level = DEFAULT_LEVEL
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("%q must be \"debug\", \"info\", \"warn\" or \"error\"", level)
+	}
+	return l, nil
+}
+
+// New creates a logger writing to w in the configured format and level.
+// Attributes added to a context with With are included in every record logged with that context.
+func New(config Config, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("%q must be \"text\" or \"json\"", config.Format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+type contextKey struct{}
+
+// With returns a copy of ctx whose log records also carry args, given as key/value pairs or slog.Attrs
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := slog.Group("", args...).Value.Group()
+	if existing, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		attrs = append(append(make([]slog.Attr, 0, len(existing)+len(attrs)), existing...), attrs...)
+	}
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// contextHandler adds the attributes stored by With to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestContextAttrs(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{Format: "json", Level: "debug"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := With(context.Background(), KEY_RUN_ID, "run-1", KEY_TENANT, "north")
+	personCtx := With(ctx, KEY_PERSON_ID, 7)
+	logger.DebugContext(personCtx, "Has sold", KEY_MLS_ID, "M-1")
+	logger.InfoContext(ctx, "Sent report")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records:\n%s", len(lines), out.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{KEY_RUN_ID: "run-1", KEY_TENANT: "north", KEY_PERSON_ID: 7.0, KEY_MLS_ID: "M-1"} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %v", key, record[key], want)
+		}
+	}
+
+	// Attributes added for one person don't leak into the parent context
+	if strings.Contains(lines[1], KEY_PERSON_ID) {
+		t.Errorf("parent context has person attributes: %s", lines[1])
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Warn("shown")
+	if strings.Contains(out.String(), "hidden") || !strings.Contains(out.String(), "level=WARN msg=shown") {
+		t.Errorf("default config logged:\n%s", out.String())
+	}
+
+	if level, err := ParseLevel("WARN"); err != nil || level != slog.LevelWarn {
+		t.Errorf("ParseLevel(WARN) = %v, %v", level, err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, config := range []Config{{Level: "verbose"}, {Format: "xml"}} {
+		if _, err := New(config, &bytes.Buffer{}); err == nil {
+			t.Errorf("New(%+v) succeeded", config)
+		}
+	}
+}
@@ -1,107 +1,287 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // mls.time_zone works without zoneinfo installed, e.g. in a scratch container
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"for-sale-report/config"
+	"for-sale-report/dashboard"
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/logging"
+	"for-sale-report/metrics"
+	"for-sale-report/report"
+	"for-sale-report/tracing"
 )
 
-func handleLookupResults(fub *FUB, results []int) {
-	for _, person := range results {
-		err := fub.SetPersonHasSold(person)
+var tracer = otel.Tracer("for-sale-report")
+
+// shutdownTracing flushes spans not yet exported; set up by main
+var shutdownTracing = func(context.Context) error { return nil }
+
+// newRunID names a run by its start time; it is used to find the run's journal for rollback.
+// The random suffix keeps runs started in the same second, like two re-checks, from sharing a journal.
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// runTenant checks every smart list of one tenant and emails its report.
+// Errors are returned rather than fatal so other tenants still run.
+func runTenant(ctx context.Context, cfg *config.Config, tenant *config.Tenant, mailer *report.Mailer, runID string, m *metrics.Metrics, locks *tenantLocks) (err error) {
+	defer locks.lock(tenant.Name)()
+
+	ctx, span := tracer.Start(ctx, "tenant", trace.WithAttributes(
+		attribute.String(logging.KEY_TENANT, tenant.Name),
+		attribute.String("mode", tenant.Mode),
+	))
+	defer tracing.End(span, &err)
+
+	record := history.Start(cfg.RunsDir(tenant), tenant.Name, runID, tenant.Mode)
+	defer finishRecord(ctx, record, &err)
+
+	// Init services used in main loop
+	tr, err := newTenantRun(ctx, cfg, tenant, runID, m, record)
+	if err != nil {
+		return err
+	}
+	defer tr.close()
+
+	setupCtx := logging.With(ctx, logging.KEY_PHASE, logging.PHASE_SETUP)
+	smartLists, err := tr.client.SellerLists(setupCtx)
+	if err != nil {
+		return err
+	}
+	if err := tr.login(setupCtx); err != nil {
+		return err
+	}
+
+	// Gather everyone first so people in several lists are only checked once
+	fetchCtx := logging.With(ctx, logging.KEY_PHASE, logging.PHASE_FETCH)
+	people, stats, err := tr.client.CollectPeople(fetchCtx, smartLists)
+	if err != nil {
+		return err
+	}
+	tr.record.AddSmartLists(stats)
+	for _, list := range stats {
+		m.PeopleScanned(tenant.Name, list.Name, list.People)
+		slog.InfoContext(fetchCtx, "Fetched smart list", logging.KEY_SMART_LIST_ID, list.ID, "name", list.Name, "people", list.People, "duration", list.Duration)
+	}
+	slog.InfoContext(fetchCtx, "Collected people", "people", len(people), "smart_lists", len(smartLists))
+
+	// Final context for sending out email
+	soldPeople := make([]fub.ListedPerson, 0)
+
+	for _, person := range people {
+		hasSold, err := tr.check(ctx, person)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		if hasSold {
+			soldPeople = append(soldPeople, person)
 		}
-		log.Printf("[INFO] %v: Stage updated - Has Sold", person)
 	}
+
+	soldPeople, intro, err := tr.finish(ctx, soldPeople)
+	if err != nil {
+		return err
+	}
+
+	// Send out email report
+	title := fmt.Sprintf("Sold Listings - %s - %s", tenant.Name, time.Now().Format(time.DateOnly))
+	if err = mailer.Send(ctx, title, tenant.ReportTo, intro, soldPeople, tr.failures); err != nil {
+		return fmt.Errorf("failed to send email report: %w", err)
+	}
+	slog.InfoContext(logging.With(ctx, logging.KEY_PHASE, logging.PHASE_REPORT), "Sent email report", "to", tenant.ReportTo, "people", len(soldPeople))
+
+	return nil
 }
 
-func main() {
-	initConfig()
+// initConfig loads the configuration file named by the -config flag, generating a
+// default one if it doesn't exist yet, and sets up logging as configured
+func initConfig() *config.Config {
+	// Parse command line flags
+	configPath := flag.String("config", "config.toml", "path to configuration file")
+	flag.Parse()
 
-	// Confirm SMTP server is reachable
-	err := VerifySMTPAuth(AppConfig.SMTP.Host, AppConfig.SMTP.Port, AppConfig.SMTP.User, AppConfig.SMTP.Pass)
-	if err != nil {
-		log.Fatal(err)
+	// Check if config file exists
+	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
+		fmt.Printf("Config file not found at %s, generating default config...\n", *configPath)
+
+		if err := config.GenerateDefault(*configPath); err != nil {
+			fatal(context.Background(), "Failed to generate default config file", err)
+		}
+
+		fmt.Printf("Default config file created at %s\n", *configPath)
+		fmt.Println("Please edit the configuration file with your settings and run the application again.")
+		os.Exit(0)
 	}
 
-	// Init services used in main loop
-	fub := NewFUB(AppConfig.FUB.APIKey, AppConfig.FUB.SellerSmartlistIDs)
-	mls, err := BuildMLS(AppConfig.MLS.User, AppConfig.MLS.Pass)
+	// Load and validate configuration
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Panic(err)
+		// Every problem is listed on its own line, which reads better than a log record
+		var configErr *config.Error
+		if errors.As(err, &configErr) {
+			fmt.Fprintf(os.Stderr, "Configuration validation failed: %v\n", err)
+			os.Exit(1)
+		}
+		fatal(context.Background(), "Failed to load config", err)
 	}
-	defer mls.Close()
 
-	// Final context for sending out email
-	updatedPeople := make([]Person, 0)
+	logger, err := logging.New(cfg.Log, os.Stderr)
+	if err != nil {
+		fatal(context.Background(), "Failed to set up logging", err)
+	}
+	slog.SetDefault(logger)
 
-	// Iterate through each smart list ID
-	for _, smartListId := range fub.sellerListIds {
-		log.Printf("[INFO] Processing Smart List ID: %v", smartListId)
+	slog.Info("Configuration loaded", "path", *configPath)
+	return cfg
+}
 
-		// Loop Context for this smart list
-		isEnd := false
-		offset := 0
+// fatal logs err and exits, after exporting any pending spans
+func fatal(ctx context.Context, msg string, err error) {
+	slog.ErrorContext(ctx, msg, "error", err)
+	flushTraces()
+	os.Exit(1)
+}
 
-		for {
-			// Break context
-			if isEnd {
-				break
-			}
+// flushTraces gives the exporter a few seconds to send spans not yet exported
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("Failed to export traces", "error", err)
+	}
+}
 
-			/*
-			 * Handle parsing a page of people
-			 */
-			// Fetch current people for this specific smart list
-			var currentPeople []Person
-			currentPeople, isEnd, err = fub.GetPeoplePage(smartListId, offset)
-			if err != nil {
-				log.Panic(err)
-			}
+// run processes every tenant, returning an error if any of them failed.
+// Every change made to FUB is journaled under runID so it can be rolled back.
+// locks, which may be nil, is shared with anything else running tenants in this process.
+func run(ctx context.Context, cfg *config.Config, runID string, m *metrics.Metrics, locks *tenantLocks) (err error) {
+	ctx = logging.With(ctx, logging.KEY_RUN_ID, runID)
+	ctx, span := tracer.Start(ctx, "run", trace.WithAttributes(attribute.String(logging.KEY_RUN_ID, runID)))
+	defer tracing.End(span, &err)
 
-			haveSoldIds := make([]int, 0)
-
-			for _, person := range currentPeople {
-				// Skip invalid people
-				if len(person.Addresses) == 0 {
-					log.Printf("[WARN] %v: Invalid User - No Addresses", person.ID)
-					continue
-				}
-
-				// Skip excluded stages
-				if fub.PersonIsExcluded(&person) {
-					continue
-				}
-
-				status, err := mls.PersonHasSoldSince(person, person.CreatedAt)
-				if err != nil {
-					log.Printf("[WARN] %v: %v", person.ID, err)
-					continue
-				}
-
-				if status.hasSold {
-					haveSoldIds = append(haveSoldIds, status.id)
-					updatedPeople = append(updatedPeople, person)
-				}
-			}
+	// Confirm SMTP server is reachable
+	mailer := report.NewMailer(cfg.SMTP)
+	if err := mailer.Verify(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Starting run", "undo", "rollback "+runID)
 
-			// Handle successful lookupResults
-			// Use small subset instead of doing them all at the end to avoid flooding FUB
-			handleLookupResults(&fub, haveSoldIds)
+	// Each tenant runs in isolation; one failing doesn't stop the rest
+	failed := 0
+	for i := range cfg.Tenants {
+		tenant := &cfg.Tenants[i]
+		ctx := logging.With(ctx, logging.KEY_TENANT, tenant.Name)
+		slog.InfoContext(ctx, "Starting tenant", "mode", tenant.Mode)
 
-			// Increment
-			offset += FUB_BUFFFER_AMOUNT
+		start := time.Now()
+		err := runTenant(ctx, cfg, tenant, mailer, runID, m, locks)
+		m.RunFinished(tenant.Name, time.Since(start), err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Tenant failed", "error", err)
+			failed++
+			continue
 		}
 
-		log.Printf("[INFO] Completed processing Smart List ID: %v", smartListId)
+		slog.InfoContext(ctx, "Completed tenant", "duration", time.Since(start))
 	}
 
-	// Send out email report
-	title := fmt.Sprintf("Sold Listings - %s", time.Now().Format(time.DateOnly))
-	if err = SendEmailReport(title, updatedPeople); err != nil {
-		log.Fatalf("Failed to send email report: %v", err)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tenants failed", failed, len(cfg.Tenants))
 	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	cfg := initConfig()
+	ctx := context.Background()
 
-	fmt.Print("Finished Program\n")
+	shutdown, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		fatal(ctx, "Failed to set up tracing", err)
+	}
+	shutdownTracing = shutdown
+	defer flushTraces()
+
+	switch command := flag.Arg(0); command {
+	case "", "run":
+		m := metrics.New()
+		err := run(ctx, cfg, newRunID(), m, nil)
+		if cfg.Metrics.Textfile != "" {
+			if err := m.WriteTextfile(cfg.Metrics.Textfile); err != nil {
+				slog.Warn("Failed to write metrics", "path", cfg.Metrics.Textfile, "error", err)
+			}
+		}
+		if err != nil {
+			fatal(ctx, "Run failed", err)
+		}
+		slog.Info("Finished run")
+	case "daemon":
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := daemon(ctx, cfg); err != nil {
+			fatal(ctx, "Daemon failed", err)
+		}
+	case "rollback":
+		if flag.NArg() != 2 {
+			fmt.Fprintln(flag.CommandLine.Output(), "rollback needs the ID of the run to undo")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := rollback(ctx, cfg, flag.Arg(1)); err != nil {
+			fatal(ctx, "Rollback failed", err)
+		}
+	case "dashboard":
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := dashboard.ListenAndServe(ctx, cfg.Dashboard.Listen, newDashboard(cfg, metrics.New(), nil)); err != nil {
+			fatal(ctx, "Dashboard failed", err)
+		}
+	case "review":
+		if err := listReview(cfg, os.Stdout); err != nil {
+			fatal(ctx, "Failed to list people waiting for review", err)
+		}
+	case "approve", "reject":
+		tenant, ids, err := parseReviewArgs(cfg, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s: %v\n", command, err)
+			flag.Usage()
+			os.Exit(2)
+		}
+		if command == "approve" {
+			err = approve(ctx, cfg, tenant, ids, newRunID())
+		} else {
+			err = reject(cfg, tenant, ids)
+		}
+		if err != nil {
+			fatal(ctx, "Review failed", err)
+		}
+	case "list-smartlists":
+		if err := listSmartLists(ctx, cfg, os.Stdout); err != nil {
+			fatal(ctx, "Failed to list smart lists", err)
+		}
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"for-sale-report/config"
+	"for-sale-report/fub"
+	"for-sale-report/history"
+	"for-sale-report/internal/fakefub"
+	"for-sale-report/internal/fakesmtp"
+	"for-sale-report/metrics"
+	"for-sale-report/mls"
+	"for-sale-report/report"
+)
+
+// fakeMLS answers lookups from a map of address to most recent sale date.
+// Addresses in pages fail to parse, returning the page without a screenshot like Session.Lookup.
+type fakeMLS struct {
+	mu      sync.Mutex
+	sold    map[string]time.Time
+	pages   map[string]string
+	lookups []string
+	closed  bool
+}
+
+func (f *fakeMLS) Lookup(ctx context.Context, addr string) (*mls.Match, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups = append(f.lookups, addr)
+
+	if page, ok := f.pages[addr]; ok {
+		err := fmt.Errorf("%w: Invalid JSON wrapper", mls.ErrParse)
+		return nil, &mls.PageError{Err: err, URL: "https://mls.example.com/search", Page: []byte(page)}
+	}
+	date, ok := f.sold[addr]
+	if !ok {
+		return nil, fmt.Errorf("%w - %s", mls.ErrNoResults, addr)
+	}
+	return &mls.Match{ID: "id-" + addr, MlsID: "mls-" + addr, SoldAt: date}, nil
+}
+
+func (f *fakeMLS) Close() {
+	f.closed = true
+}
+
+// useFakeMLS swaps loginMLS for the duration of the test; logins for user "locked" fail
+func useFakeMLS(t *testing.T, fake *fakeMLS) {
+	t.Helper()
+	original := loginMLS
+	loginMLS = func(ctx context.Context, config mls.Config, cache *mls.Cache, sessionPath string) (soldChecker, error) {
+		if config.User == "locked" {
+			return nil, fmt.Errorf("login failed")
+		}
+		return fake, nil
+	}
+	t.Cleanup(func() { loginMLS = original })
+}
+
+func newPerson(id int, stage string, street string, created time.Time) fub.Person {
+	person := fub.Person{
+		ID:        id,
+		Name:      "Person " + strconv.Itoa(id),
+		CreatedAt: created,
+		Stage:     stage,
+	}
+	if street != "" {
+		person.Addresses = []fub.PersonAddress{{Street: street, City: "Springfield", State: "IL", Code: "62701"}}
+	}
+	return person
+}
+
+// testEnv is a fake FUB and mail server, and a config whose tenant "north" uses them
+type testEnv struct {
+	cfg  *config.Config
+	fub  *fakefub.Server
+	smtp *fakesmtp.Server
+}
+
+// newTestEnv serves lists, keyed by smart list ID, to a tenant in mode that checks all of them.
+// The servers are closed when the test ends.
+func newTestEnv(t *testing.T, mode string, lists map[int][]fub.Person) *testEnv {
+	t.Helper()
+	fubServer := fakefub.New("key", lists)
+	t.Cleanup(fubServer.Close)
+	smtpServer, err := fakesmtp.New("mailer", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { smtpServer.Close() })
+
+	var listIDs []string
+	for _, id := range slices.Sorted(maps.Keys(lists)) {
+		listIDs = append(listIDs, strconv.Itoa(id))
+	}
+	cfg := &config.Config{
+		Version: config.CONFIG_VERSION,
+		DataDir: t.TempDir(),
+		SMTP: report.Config{
+			User:      "mailer",
+			Pass:      "secret",
+			From:      "reports@example.com",
+			Host:      smtpServer.Host(),
+			Port:      smtpServer.Port(),
+			TLSConfig: smtpServer.ClientTLSConfig(),
+		},
+		Tenants: []config.Tenant{{
+			Name:     "north",
+			Mode:     mode,
+			ReportTo: []string{"north@example.com"},
+			FUB: fub.Config{
+				APIKey:             "key",
+				SellerSmartlistIDs: listIDs,
+				BaseURL:            fubServer.URL,
+				Writes:             fub.WriteConfig{PerSecond: 1000},
+			},
+			MLS: mls.Config{User: "north", Pass: "pass"},
+		}},
+	}
+	return &testEnv{cfg: cfg, fub: fubServer, smtp: smtpServer}
+}
+
+// tenant is north's config
+func (e *testEnv) tenant() *config.Tenant {
+	return &e.cfg.Tenants[0]
+}
+
+// addLockedTenant adds a tenant "south", like north but whose MLS login fails
+func (e *testEnv) addLockedTenant() {
+	south := e.cfg.Tenants[0]
+	south.Name, south.ReportTo, south.MLS.User = "south", []string{"south@example.com"}, "locked"
+	e.cfg.Tenants = append(e.cfg.Tenants, south)
+}
+
+// runTwoTenants runs north in tag mode next to a locked south. North has one person sold since they were created,
+// one who sold before, one the MLS doesn't know and one without an address.
+func runTwoTenants(t *testing.T, m *metrics.Metrics) *testEnv {
+	t.Helper()
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	useFakeMLS(t, &fakeMLS{sold: map[string]time.Time{
+		"1 Main St, Springfield": created.AddDate(0, 3, 0),
+		"2 Main St, Springfield": created.AddDate(0, -3, 0),
+	}})
+	env := newTestEnv(t, config.MODE_TAG, map[int][]fub.Person{7: {
+		newPerson(1, "Lead", "1 Main St", created),
+		newPerson(2, "Lead", "2 Main St", created),
+		newPerson(3, "Lead", "3 Main St", created),
+		newPerson(4, "Lead", "", created),
+	}})
+	env.addLockedTenant()
+
+	err := run(context.Background(), env.cfg, "test-run", m, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 tenants failed") {
+		t.Fatalf("run() error = %v, want 1 of 2 tenants failed", err)
+	}
+	return env
+}
+
+// spans records every span ended. Package tracers only follow the first provider installed,
+// so one is installed for all the tests that need it.
+var spans = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+})
+
+func TestRunEndToEnd(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Enough people to span two pages, with a handful of interesting cases
+	people := make([]fub.Person, 0, 150)
+	for id := 1; id <= 150; id++ {
+		people = append(people, newPerson(id, "Lead", fmt.Sprintf("%d Main St", id), created))
+	}
+	people[9] = newPerson(10, "Lead", "", created)              // No address
+	people[19] = newPerson(20, "Closed", "20 Main St", created) // Excluded stage
+
+	fake := &fakeMLS{sold: map[string]time.Time{
+		"5 Main St, Springfield":   created.AddDate(0, 3, 0),  // Sold after lead was created
+		"6 Main St, Springfield":   created.AddDate(0, -3, 0), // Sold before
+		"20 Main St, Springfield":  created.AddDate(0, 3, 0),  // Sold, but excluded
+		"140 Main St, Springfield": created.AddDate(1, 0, 0),  // Sold, on the second page
+	}}
+	useFakeMLS(t, fake)
+
+	// List 8 overlaps with list 7; those people must only be checked and tagged once
+	env := newTestEnv(t, config.MODE_TAG, map[int][]fub.Person{7: people, 8: {people[4], people[139], people[5]}})
+	env.tenant().FUB.ExcludedStages = []string{"Closed"}
+	env.addLockedTenant()
+
+	err := run(context.Background(), env.cfg, "test-run", metrics.New(), nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 tenants failed") {
+		t.Fatalf("run() error = %v, want 1 of 2 tenants failed", err)
+	}
+
+	// Only people sold after they were created are tagged
+	var tagged []int
+	for _, put := range env.fub.Puts() {
+		tagged = append(tagged, put.PersonID)
+		if put.Query.Get("mergeTags") != "true" || !strings.Contains(put.Body, "Expired Lead") {
+			t.Errorf("unexpected PUT for %d: %v %s", put.PersonID, put.Query, put.Body)
+		}
+	}
+	if fmt.Sprint(tagged) != "[5 140]" {
+		t.Errorf("tagged = %v, want [5 140]", tagged)
+	}
+
+	// Excluded and address-less people are never looked up
+	for _, lookup := range fake.lookups {
+		if strings.HasPrefix(lookup, "20 Main St") || lookup == "" {
+			t.Errorf("unexpected MLS lookup %q", lookup)
+		}
+	}
+	if len(fake.lookups) != 148 {
+		t.Errorf("got %d MLS lookups, want 148", len(fake.lookups))
+	}
+	if !fake.closed {
+		t.Error("MLS session was not closed")
+	}
+
+	// Only the healthy tenant sends a report
+	messages := env.smtp.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails, want 1", len(messages))
+	}
+	msg := messages[0]
+	if fmt.Sprint(msg.To) != "[north@example.com]" {
+		t.Errorf("email sent to %v", msg.To)
+	}
+	for _, want := range []string{"Subject: Sold Listings - north - ", "Person 5", "Person 140", "Smart List 7<br>Smart List 8"} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("email missing %q", want)
+		}
+	}
+	if strings.Contains(msg.Data, "Person 6<") || strings.Contains(msg.Data, "Person 20<") {
+		t.Error("email lists people that were not tagged")
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	m := metrics.New()
+	runTwoTenants(t, m)
+
+	// Lookups, FUB requests and both runs are counted
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`for_sale_report_mls_lookups_total{outcome="sold",tenant="north"} 1`,
+		`for_sale_report_mls_lookups_total{outcome="not_sold",tenant="north"} 1`,
+		`for_sale_report_mls_lookups_total{outcome="no_results",tenant="north"} 1`,
+		`for_sale_report_people_scanned_total{smart_list="Smart List 7",tenant="north"} 4`,
+		`for_sale_report_fub_request_duration_seconds_count{code="200",method="put",tenant="north"} 1`,
+		`for_sale_report_runs_total{result="failure",tenant="south"} 1`,
+		`for_sale_report_runs_total{result="success",tenant="north"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestRunTracing(t *testing.T) {
+	recorder := spans()
+	before := len(recorder.Ended())
+	runTwoTenants(t, metrics.New())
+
+	// The run is traced down to each page, check, write and email
+	counts := make(map[string]int)
+	tenantSpans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended()[before:] {
+		counts[span.Name()]++
+		if span.Name() == "tenant" {
+			tenantSpans[span.Attributes()[0].Value.AsString()] = span
+		}
+	}
+	for name, want := range map[string]int{"run": 1, "tenant": 2, "check": 4, "fub.GetPeoplePage": 1, "fub.write": 1, "smtp.send": 1} {
+		if counts[name] != want {
+			t.Errorf("got %d %q spans, want %d", counts[name], name, want)
+		}
+	}
+	if status := tenantSpans["south"].Status(); status.Code != codes.Error {
+		t.Errorf("failed tenant span status = %v", status)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	env := runTwoTenants(t, metrics.New())
+
+	// Both tenants' runs are recorded, including why one failed
+	runs, err := history.Runs(env.cfg.RunsDir(env.tenant()))
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("history.Runs() = %v, %v", runs, err)
+	}
+	if got := runs[0]; len(got.Checks) != 4 || got.Count(history.RESULT_SOLD) != 1 || got.Count(history.RESULT_SKIPPED) != 1 || len(got.SmartLists) != 1 {
+		t.Errorf("north run recorded %d checks, %d sold, %d skipped, %d smart lists", len(got.Checks), got.Count(history.RESULT_SOLD), got.Count(history.RESULT_SKIPPED), len(got.SmartLists))
+	}
+	runs, _ = history.Runs(env.cfg.RunsDir(&env.cfg.Tenants[1]))
+	if len(runs) != 1 || !strings.Contains(runs[0].Error, "login failed") {
+		t.Errorf("south run was not recorded with its error: %v", runs)
+	}
+}
+
+func TestRunRecordsSetupFailures(t *testing.T) {
+	useFakeMLS(t, &fakeMLS{})
+	env := newTestEnv(t, config.MODE_TAG, map[int][]fub.Person{7: nil})
+	env.tenant().FUB.APIKey = "revoked-key"
+	env.tenant().FUB.ExcludedStages = []string{"Closed"}
+
+	if err := run(context.Background(), env.cfg, "setup-run", metrics.New(), nil); err == nil || !strings.Contains(err.Error(), "1 of 1 tenants failed") {
+		t.Fatalf("run() error = %v, want 1 of 1 tenants failed", err)
+	}
+
+	// The run failed resolving stages, before it was set up, but is still listed with why
+	runs, err := history.Runs(env.cfg.RunsDir(env.tenant()))
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("history.Runs() = %v, %v", runs, err)
+	}
+	if runs[0].ID != "setup-run" || runs[0].Error == "" || runs[0].Finished.IsZero() {
+		t.Errorf("setup failure recorded as %+v", runs[0])
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	// Runs started in the same second still get their own journal
+	first, second := newRunID(), newRunID()
+	if first == second {
+		t.Errorf("newRunID() returned %q twice", first)
+	}
+	if _, err := time.Parse("20060102-150405", first[:15]); err != nil || len(first) != 22 {
+		t.Errorf("newRunID() = %q, want <start time>-<6 hex digits>", first)
+	}
+}
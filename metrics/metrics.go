@@ -0,0 +1,158 @@
+// Package metrics collects Prometheus metrics about runs, MLS lookups and FUB requests
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"for-sale-report/mls"
+)
+
+// Outcome of an MLS lookup
+const (
+	OUTCOME_SOLD        = "sold"
+	OUTCOME_NOT_SOLD    = "not_sold"
+	OUTCOME_NO_RESULTS  = "no_results"
+	OUTCOME_PARSE_ERROR = "parse_error"
+	OUTCOME_TIMEOUT     = "timeout"
+	OUTCOME_ERROR       = "error" // Any other failure
+)
+
+// Config represents metrics-related configuration
+type Config struct {
+	Textfile string `toml:"textfile"` // .prom file written after each run for the node_exporter textfile collector, disabled when empty
+}
+
+// Metrics holds every metric the tool reports, in its own registry
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	peopleScanned *prometheus.CounterVec
+	lookups       *prometheus.CounterVec
+	fubRequests   *prometheus.HistogramVec
+	runDuration   *prometheus.HistogramVec
+	runs          *prometheus.CounterVec
+	lastRun       *prometheus.GaugeVec
+	lastSuccess   *prometheus.GaugeVec
+}
+
+// New creates and registers the metrics
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		peopleScanned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "for_sale_report_people_scanned_total",
+			Help: "People returned by each seller smart list.",
+		}, []string{"tenant", "smart_list"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "for_sale_report_mls_lookups_total",
+			Help: "MLS lookups by outcome.",
+		}, []string{"tenant", "outcome"}),
+		fubRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "for_sale_report_fub_request_duration_seconds",
+			Help:    "Latency of Follow Up Boss API requests by method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tenant", "method", "code"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "for_sale_report_run_duration_seconds",
+			Help:    "Time taken by each tenant's run.",
+			Buckets: prometheus.ExponentialBuckets(30, 2, 10), // 30s to ~4h
+		}, []string{"tenant"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "for_sale_report_runs_total",
+			Help: "Tenant runs by result.",
+		}, []string{"tenant", "result"}),
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "for_sale_report_last_run_timestamp_seconds",
+			Help: "When each tenant's last run finished.",
+		}, []string{"tenant"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "for_sale_report_last_success_timestamp_seconds",
+			Help: "When each tenant's last successful run finished.",
+		}, []string{"tenant"}),
+	}
+
+	m.Registry.MustRegister(m.peopleScanned, m.lookups, m.fubRequests, m.runDuration, m.runs, m.lastRun, m.lastSuccess)
+	return m
+}
+
+// RegisterProcess adds Go runtime and process metrics, for long running processes
+func (m *Metrics) RegisterProcess() {
+	m.Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics for scraping
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics to path for the node_exporter textfile collector
+func (m *Metrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.Registry)
+}
+
+// InstrumentFUB returns a copy of client (http.DefaultClient when nil) that times every request
+func (m *Metrics) InstrumentFUB(tenant string, client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	instrumented := *client
+	instrumented.Transport = promhttp.InstrumentRoundTripperDuration(
+		m.fubRequests.MustCurryWith(prometheus.Labels{"tenant": tenant}),
+		transport,
+	)
+	return &instrumented
+}
+
+// PeopleScanned counts the people a smart list returned
+func (m *Metrics) PeopleScanned(tenant string, smartList string, people int) {
+	m.peopleScanned.WithLabelValues(tenant, smartList).Add(float64(people))
+}
+
+// Lookup counts one MLS lookup with the outcome of sold and err
+func (m *Metrics) Lookup(tenant string, sold bool, err error) {
+	m.lookups.WithLabelValues(tenant, LookupOutcome(sold, err)).Inc()
+}
+
+// RunFinished records a tenant's run that took duration and failed with err, if not nil
+func (m *Metrics) RunFinished(tenant string, duration time.Duration, err error) {
+	now := float64(time.Now().Unix())
+	m.runDuration.WithLabelValues(tenant).Observe(duration.Seconds())
+	m.lastRun.WithLabelValues(tenant).Set(now)
+
+	if err != nil {
+		m.runs.WithLabelValues(tenant, "failure").Inc()
+		return
+	}
+	m.runs.WithLabelValues(tenant, "success").Inc()
+	m.lastSuccess.WithLabelValues(tenant).Set(now)
+}
+
+// LookupOutcome classifies the result of an MLS lookup
+func LookupOutcome(sold bool, err error) string {
+	switch {
+	case err == nil && sold:
+		return OUTCOME_SOLD
+	case err == nil:
+		return OUTCOME_NOT_SOLD
+	case errors.Is(err, mls.ErrNoResults):
+		return OUTCOME_NO_RESULTS
+	case errors.Is(err, mls.ErrParse):
+		return OUTCOME_PARSE_ERROR
+	case errors.Is(err, context.DeadlineExceeded):
+		return OUTCOME_TIMEOUT
+	default:
+		return OUTCOME_ERROR
+	}
+}
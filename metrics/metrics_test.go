@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"for-sale-report/mls"
+)
+
+func TestLookupOutcome(t *testing.T) {
+	tests := []struct {
+		sold bool
+		err  error
+		want string
+	}{
+		{true, nil, OUTCOME_SOLD},
+		{false, nil, OUTCOME_NOT_SOLD},
+		{false, fmt.Errorf("%w - 1 Main St", mls.ErrNoResults), OUTCOME_NO_RESULTS},
+		{false, fmt.Errorf("%w: Invalid JSON wrapper", mls.ErrParse), OUTCOME_PARSE_ERROR},
+		{false, fmt.Errorf("navigate: %w", context.DeadlineExceeded), OUTCOME_TIMEOUT},
+		{false, errors.New("browser crashed"), OUTCOME_ERROR},
+	}
+	for _, test := range tests {
+		if got := LookupOutcome(test.sold, test.err); got != test.want {
+			t.Errorf("LookupOutcome(%v, %v) = %q, want %q", test.sold, test.err, got, test.want)
+		}
+	}
+}
+
+func TestTextfile(t *testing.T) {
+	m := New()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	res, err := m.InstrumentFUB("north", nil).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	m.PeopleScanned("north", "Past Sellers", 12)
+	m.Lookup("north", true, nil)
+	m.Lookup("north", false, mls.ErrNoResults)
+	m.RunFinished("north", time.Minute, nil)
+	m.RunFinished("south", time.Second, errors.New("login failed"))
+
+	path := filepath.Join(t.TempDir(), "for_sale_report.prom")
+	if err := m.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`for_sale_report_people_scanned_total{smart_list="Past Sellers",tenant="north"} 12`,
+		`for_sale_report_mls_lookups_total{outcome="sold",tenant="north"} 1`,
+		`for_sale_report_mls_lookups_total{outcome="no_results",tenant="north"} 1`,
+		`for_sale_report_fub_request_duration_seconds_count{code="429",method="get",tenant="north"} 1`,
+		`for_sale_report_run_duration_seconds_sum{tenant="north"} 60`,
+		`for_sale_report_runs_total{result="failure",tenant="south"} 1`,
+		`for_sale_report_last_success_timestamp_seconds{tenant="north"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), `last_success_timestamp_seconds{tenant="south"}`) {
+		t.Error("failed run recorded as a success")
+	}
+}
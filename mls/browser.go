@@ -0,0 +1,73 @@
+package mls
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// BrowserConfig controls the Chrome used to log in
+type BrowserConfig struct {
+	Headless    *bool  `toml:"headless"`      // Run without a window, true when unset
+	ExecPath    string `toml:"exec_path"`     // Chrome or Chromium binary, found on the PATH when empty
+	Proxy       string `toml:"proxy"`         // Proxy server, e.g. http://proxy.example.com:3128 or socks5://127.0.0.1:1080
+	UserAgent   string `toml:"user_agent"`    // Overrides the browser's user agent, which lookups also send
+	NoSandbox   bool   `toml:"no_sandbox"`    // Needed to run as root, e.g. in a container
+	UserDataDir string `toml:"user_data_dir"` // Persistent profile directory, a temporary one when empty
+
+	// DevTools endpoint of an already running Chrome, such as ws://127.0.0.1:9222 or http://chrome:9222.
+	// The options above for starting Chrome cannot be combined with it; headless is ignored.
+	RemoteURL string `toml:"remote_url"`
+}
+
+// allocator returns a context that starts, or connects to, Chrome as configured
+func (config BrowserConfig) allocator(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.RemoteURL != "" {
+		return chromedp.NewRemoteAllocator(ctx, config.RemoteURL)
+	}
+	return chromedp.NewExecAllocator(ctx, config.execOptions()...)
+}
+
+func (config BrowserConfig) execOptions() []chromedp.ExecAllocatorOption {
+	options := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+
+	if config.Headless != nil && !*config.Headless {
+		options = append(options, chromedp.Flag("headless", false))
+	}
+	if config.ExecPath != "" {
+		options = append(options, chromedp.ExecPath(config.ExecPath))
+	}
+	if config.Proxy != "" {
+		options = append(options, chromedp.ProxyServer(config.Proxy))
+	}
+	if config.UserAgent != "" {
+		options = append(options, chromedp.UserAgent(config.UserAgent))
+	}
+	if config.NoSandbox {
+		options = append(options, chromedp.NoSandbox)
+	}
+	if config.UserDataDir != "" {
+		options = append(options, chromedp.UserDataDir(config.UserDataDir))
+	}
+	return options
+}
+
+// ProxyURL returns the configured proxy for plain HTTP requests, or nil when there is none.
+// Like Chrome, a proxy without a scheme is taken to be an HTTP proxy.
+func (config BrowserConfig) ProxyURL() (*url.URL, error) {
+	if config.Proxy == "" {
+		return nil, nil
+	}
+	proxy := config.Proxy
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("proxy %q is not a valid URL", config.Proxy)
+	}
+	return u, nil
+}
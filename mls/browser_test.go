@@ -0,0 +1,64 @@
+package mls
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxyURL(t *testing.T) {
+	tests := []struct {
+		proxy string
+		want  string
+	}{
+		{"", ""},
+		{"proxy.example.com:3128", "http://proxy.example.com:3128"},
+		{"socks5://127.0.0.1:1080", "socks5://127.0.0.1:1080"},
+	}
+	for _, test := range tests {
+		u, err := BrowserConfig{Proxy: test.proxy}.ProxyURL()
+		if err != nil {
+			t.Errorf("ProxyURL(%q): %v", test.proxy, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != test.want {
+			t.Errorf("ProxyURL(%q) = %q, want %q", test.proxy, got, test.want)
+		}
+	}
+
+	if _, err := (BrowserConfig{Proxy: "http://"}).ProxyURL(); err == nil {
+		t.Error("proxy without a host accepted")
+	}
+}
+
+func TestLookupThroughProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.IsAbs() {
+			proxied.Add(1)
+		}
+		http.Error(w, "blocked", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	server, requests := newTestServer(t)
+	config := testConfig(server)
+	config.Browser.Proxy = proxy.URL
+	session, err := newSession(config, testCookies(server, "abc"), "TestBrowser/1.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Lookup(context.Background(), "1 Main St, Springfield"); err == nil {
+		t.Error("lookup succeeded around the proxy")
+	}
+	if proxied.Load() != 1 || len(requests()) != 0 {
+		t.Errorf("%d proxied requests, %d direct", proxied.Load(), len(requests()))
+	}
+}
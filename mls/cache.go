@@ -0,0 +1,190 @@
+package mls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"for-sale-report/internal/atomicfile"
+)
+
+const DEFAULT_SEARCH_TTL = 90 * 24 * time.Hour // Address to listing IDs, which almost never change
+const DEFAULT_HISTORY_TTL = 72 * time.Hour     // Listing history, which changes when a property sells
+
+// CacheConfig controls how long lookups are reused between runs
+type CacheConfig struct {
+	Disabled   bool          `toml:"disabled"`
+	SearchTTL  time.Duration `toml:"search_ttl"`  // How long an address keeps its Id/MlsId, DEFAULT_SEARCH_TTL when 0
+	HistoryTTL time.Duration `toml:"history_ttl"` // How long a listing keeps its last sale date, DEFAULT_HISTORY_TTL when 0
+}
+
+type cachedSearch struct {
+	ID       string    `json:"id"`
+	MlsID    string    `json:"mlsId"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+type cachedHistory struct {
+	SoldAt   time.Time `json:"soldAt"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+// Cache keeps search results by normalized address and listing history by Id/MlsId
+// so repeated addresses don't need the browser. Failed lookups are never cached.
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	mu         sync.Mutex
+	path       string
+	searchTTL  time.Duration
+	historyTTL time.Duration
+	now        func() time.Time
+	file       cacheFile
+}
+
+// cacheFile is what is saved to disk
+type cacheFile struct {
+	Searches map[string]cachedSearch  `json:"searches"` // By normalized address
+	History  map[string]cachedHistory `json:"history"`  // By "Id/MlsId"
+}
+
+// OpenCache loads the cache saved at path, which may not exist yet.
+// It returns nil when the cache is disabled.
+func OpenCache(path string, config CacheConfig) (*Cache, error) {
+	if config.Disabled {
+		return nil, nil
+	}
+
+	c := &Cache{
+		path:       path,
+		searchTTL:  config.SearchTTL,
+		historyTTL: config.HistoryTTL,
+		now:        time.Now,
+		file: cacheFile{
+			Searches: make(map[string]cachedSearch),
+			History:  make(map[string]cachedHistory),
+		},
+	}
+	if c.searchTTL == 0 {
+		c.searchTTL = DEFAULT_SEARCH_TTL
+	}
+	if c.historyTTL == 0 {
+		c.historyTTL = DEFAULT_HISTORY_TTL
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MLS cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.file); err != nil {
+		return nil, fmt.Errorf("failed to read MLS cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Cache) search(addr string) (*Match, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.file.Searches[NormalizeAddress(addr)]
+	if !ok || c.now().Sub(entry.CachedAt) > c.searchTTL {
+		return nil, false
+	}
+	return &Match{ID: entry.ID, MlsID: entry.MlsID}, true
+}
+
+func (c *Cache) putSearch(addr string, match *Match) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file.Searches[NormalizeAddress(addr)] = cachedSearch{ID: match.ID, MlsID: match.MlsID, CachedAt: c.now()}
+}
+
+func (c *Cache) history(match *Match) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.file.History[match.ID+"/"+match.MlsID]
+	if !ok || c.now().Sub(entry.CachedAt) > c.historyTTL {
+		return time.Time{}, false
+	}
+	return entry.SoldAt, true
+}
+
+func (c *Cache) putHistory(match *Match) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file.History[match.ID+"/"+match.MlsID] = cachedHistory{SoldAt: match.SoldAt, CachedAt: c.now()}
+}
+
+// Save writes the cache to disk, dropping expired entries
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, entry := range c.file.Searches {
+		if now.Sub(entry.CachedAt) > c.searchTTL {
+			delete(c.file.Searches, key)
+		}
+	}
+	for key, entry := range c.file.History {
+		if now.Sub(entry.CachedAt) > c.historyTTL {
+			delete(c.file.History, key)
+		}
+	}
+
+	data, err := json.Marshal(c.file)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save MLS cache: %w", err)
+	}
+	return nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Common spellings reduced to the USPS abbreviation, so "123 North Main Street" and "123 N Main St." match
+var addressWords = map[string]string{
+	"north": "n", "south": "s", "east": "e", "west": "w",
+	"northeast": "ne", "northwest": "nw", "southeast": "se", "southwest": "sw",
+	"street": "st", "avenue": "ave", "av": "ave", "road": "rd", "drive": "dr", "lane": "ln",
+	"boulevard": "blvd", "court": "ct", "circle": "cir", "place": "pl", "terrace": "ter",
+	"parkway": "pkwy", "highway": "hwy", "trail": "trl",
+	"apartment": "apt", "suite": "ste",
+}
+
+// NormalizeAddress reduces an address to lower case words without punctuation,
+// with common street types and directions abbreviated
+func NormalizeAddress(addr string) string {
+	words := strings.Fields(nonAlphanumeric.ReplaceAllString(strings.ToLower(addr), " "))
+	for i, word := range words {
+		if short, ok := addressWords[word]; ok {
+			words[i] = short
+		}
+	}
+	return strings.Join(words, " ")
+}
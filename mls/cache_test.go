@@ -0,0 +1,86 @@
+package mls
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"123 North Main Street, Springfield, IL 62701", "123 n main st springfield il 62701"},
+		{"123 N. Main St., Springfield IL  62701", "123 n main st springfield il 62701"},
+		{"  45 Oak AVENUE Apt #4 ", "45 oak ave apt 4"},
+		{"9 Southwest Parkway, Suite 200", "9 sw pkwy ste 200"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeAddress(test.addr); got != test.want {
+			t.Errorf("NormalizeAddress(%q) = %q, want %q", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mls_cache.json")
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cache, err := OpenCache(path, CacheConfig{HistoryTTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.now = func() time.Time { return now }
+
+	match := &Match{ID: "20001", MlsID: "M-1", SoldAt: now.AddDate(0, -1, 0)}
+	cache.putSearch("123 North Main Street, Springfield", match)
+	cache.putHistory(match)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries survive a reload and are found under other spellings of the address
+	cache, err = OpenCache(path, CacheConfig{HistoryTTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(48 * time.Hour)
+	cache.now = func() time.Time { return now }
+
+	found, ok := cache.search("123 N Main St Springfield")
+	if !ok || found.ID != "20001" || found.MlsID != "M-1" {
+		t.Fatalf("search = %+v, %v", found, ok)
+	}
+
+	// History expires sooner than searches
+	if _, ok := cache.history(found); ok {
+		t.Error("history older than its TTL was used")
+	}
+	match.SoldAt = now
+	cache.putHistory(match)
+	if soldAt, ok := cache.history(found); !ok || !soldAt.Equal(now) {
+		t.Errorf("history = %v, %v", soldAt, ok)
+	}
+
+	now = now.Add(DEFAULT_SEARCH_TTL)
+	if _, ok := cache.search("123 N Main St Springfield"); ok {
+		t.Error("search older than its TTL was used")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "mls_cache.json"), CacheConfig{Disabled: true})
+	if err != nil || cache != nil {
+		t.Fatalf("OpenCache = %v, %v, want nil", cache, err)
+	}
+
+	cache.putSearch("1 Main St", &Match{ID: "1"})
+	if _, ok := cache.search("1 Main St"); ok {
+		t.Error("disabled cache returned a search")
+	}
+	if err := cache.Save(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,53 @@
+package mls
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Layouts of the dates FlexMLS shows in listing history, tried in order.
+// Months, days and hours may have one or two digits.
+var historyDateLayouts = []string{
+	"1/2/2006",
+	"1/2/06",
+	"1/2/2006 3:04 PM",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 15:04",
+	"1/2/2006 15:04:05",
+	"1/2/06 3:04 PM",
+	"1/2/06 15:04",
+	"1-2-2006",
+	"1-2-06",
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"Jan 2, 2006",
+	"January 2, 2006",
+}
+
+// Lower case or dotted AM/PM after a time, with or without a space before it
+var meridiem = regexp.MustCompile(`(?i)(\d)\s*([ap])\.?m\.?$`)
+
+// parseHistoryDate reads a listing history date in the market's time zone loc.
+// Dates with an explicit offset keep it.
+func parseHistoryDate(date string, loc *time.Location) (time.Time, error) {
+	value := strings.Join(strings.Fields(date), " ")
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%w: Empty date in listing history", ErrParse)
+	}
+	value = meridiem.ReplaceAllStringFunc(value, func(m string) string {
+		sub := meridiem.FindStringSubmatch(m)
+		return sub[1] + " " + strings.ToUpper(sub[2]) + "M"
+	})
+
+	for _, layout := range historyDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: Unrecognized date %q in listing history, expected one like 03/14/2025", ErrParse, date)
+}
@@ -0,0 +1,88 @@
+package mls
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryDate(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		date string
+		loc  *time.Location
+		want time.Time
+	}{
+		{"03/14/2025", time.UTC, time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"3/4/2025", time.UTC, time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"  3/4/2025\n", time.UTC, time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"3/4/25", time.UTC, time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"03/14/99", time.UTC, time.Date(1999, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"3-4-2025", time.UTC, time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"2025-03-14", time.UTC, time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"Mar 14, 2025", time.UTC, time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"March 14, 2025", time.UTC, time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)},
+
+		// With a time
+		{"3/14/2025 2:30 PM", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"3/14/2025 2:30pm", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"3/14/2025 9:05 a.m.", time.UTC, time.Date(2025, 3, 14, 9, 5, 0, 0, time.UTC)},
+		{"3/14/2025 2:30:15 PM", time.UTC, time.Date(2025, 3, 14, 14, 30, 15, 0, time.UTC)},
+		{"3/14/2025 14:30", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"3/14/25 2:30 PM", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"2025-03-14 14:30:00", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"2025-03-14T14:30:00", time.UTC, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+
+		// In the market's time zone, unless the date has its own offset
+		{"03/14/2025", chicago, time.Date(2025, 3, 14, 5, 0, 0, 0, time.UTC)},
+		{"1/2/2025 11:00 PM", chicago, time.Date(2025, 1, 3, 5, 0, 0, 0, time.UTC)},
+		{"2025-03-14T14:30:00Z", chicago, time.Date(2025, 3, 14, 14, 30, 0, 0, time.UTC)},
+		{"2025-03-14T14:30:00-04:00", chicago, time.Date(2025, 3, 14, 18, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryDate(tt.date, tt.loc)
+		if err != nil {
+			t.Errorf("parseHistoryDate(%q) = %v", tt.date, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseHistoryDate(%q, %v) = %v, want %v", tt.date, tt.loc, got.UTC(), tt.want)
+		}
+	}
+}
+
+func TestParseHistoryDateErrors(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"", "Failed to parse MLS page: Empty date in listing history"},
+		{"  ", "Failed to parse MLS page: Empty date in listing history"},
+		{"N/A", `Failed to parse MLS page: Unrecognized date "N/A" in listing history, expected one like 03/14/2025`},
+		{"13/14/2025", `Failed to parse MLS page: Unrecognized date "13/14/2025" in listing history, expected one like 03/14/2025`},
+		{"3/14/2025 25:00", `Failed to parse MLS page: Unrecognized date "3/14/2025 25:00" in listing history, expected one like 03/14/2025`},
+		{"14.03.2025", `Failed to parse MLS page: Unrecognized date "14.03.2025" in listing history, expected one like 03/14/2025`},
+	}
+	for _, tt := range tests {
+		_, err := parseHistoryDate(tt.date, time.UTC)
+		if !errors.Is(err, ErrParse) || err.Error() != tt.want {
+			t.Errorf("parseHistoryDate(%q) error = %v, want %q", tt.date, err, tt.want)
+		}
+	}
+}
+
+func TestConfigLocation(t *testing.T) {
+	if loc, err := (Config{}).Location(); err != nil || loc != time.UTC {
+		t.Errorf("Location() without a time zone = %v, %v; want UTC", loc, err)
+	}
+	if loc, err := (Config{TimeZone: "America/Denver"}).Location(); err != nil || loc.String() != "America/Denver" {
+		t.Errorf("Location() = %v, %v; want America/Denver", loc, err)
+	}
+	if _, err := (Config{TimeZone: "Mountain"}).Location(); err == nil {
+		t.Error("Location() should fail for an unknown time zone")
+	}
+}
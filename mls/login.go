@@ -0,0 +1,316 @@
+package mls
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const LOGIN_STEP_TIMEOUT = 30 * time.Second // How long each login page has to move on
+const LOGIN_POLL_INTERVAL = 500 * time.Millisecond
+const CAPTURE_TIMEOUT = 10 * time.Second // How long saving the page of a failed login may take
+
+// Login errors wrap one of these
+var (
+	ErrLoginFailed  = errors.New("MLS login failed")
+	ErrMFARequired  = errors.New("MLS login needs a one-time code")
+	ErrInterstitial = errors.New("MLS login stopped at a page that needs a person")
+)
+
+// States of a page seen while logging in
+type loginPage int
+
+const (
+	pageLogin         loginPage = iota // The login form, still waiting to move on
+	pageError                          // The login form showing an error message
+	pageMFA                            // A one-time code challenge
+	pageInterstitial                   // A license agreement or notice to accept
+	pageAuthenticated                  // Anything else, once loaded
+)
+
+func loginAndGetCookies(ctx context.Context, config Config) (cookies []*network.Cookie, userAgent string, err error) {
+	defer func() {
+		if err != nil {
+			err = capturePage(ctx, err)
+		}
+	}()
+
+	err = chromedp.Run(ctx,
+		// Navigate to the login page
+		chromedp.Navigate(config.LoginURL),
+
+		// Wait for the page to load
+		chromedp.WaitVisible(`input[name="username"]`, chromedp.ByQuery),
+
+		// Fill in the username field
+		chromedp.SendKeys(`input[name="username"]`, config.User, chromedp.ByQuery),
+
+		// Fill in the password field
+		chromedp.SendKeys(`input[name="password"]`, config.Pass, chromedp.ByQuery),
+
+		// Submit the form (either click submit button or press Enter)
+		chromedp.Click(`input[type="submit"]`, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := waitForLogin(ctx, config); err != nil {
+		return nil, "", err
+	}
+
+	// Get all the necessary cookies so they can be used without the browser
+	err = chromedp.Run(ctx,
+		// Requests look like they come from the same browser
+		chromedp.Evaluate(`navigator.userAgent`, &userAgent),
+
+		// Cookies for every endpoint, which may be on different subdomains
+		chromedp.ActionFunc(func(ctx context.Context) (err error) {
+			cookies, err = network.GetCookies().WithURLs([]string{config.LoginURL, config.SearchURL, config.HistoryURL}).Do(ctx)
+			return err
+		}),
+	)
+	return cookies, userAgent, err
+}
+
+// capturePage returns err as a *PageError with the browser's current page and a screenshot of it.
+// err is returned as it is when the browser is gone or the page can't be read.
+func capturePage(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, CAPTURE_TIMEOUT)
+	defer cancel()
+
+	var location, page string
+	var screenshot []byte
+	if chromedp.Run(ctx, chromedp.Location(&location), chromedp.OuterHTML("html", &page, chromedp.ByQuery)) != nil {
+		return err
+	}
+	// A page without a screenshot is still worth keeping
+	_ = chromedp.Run(ctx, chromedp.FullScreenshot(&screenshot, 100))
+	return &PageError{Err: err, URL: location, Page: []byte(page), Screenshot: screenshot}
+}
+
+// waitForLogin follows the pages after the login form is submitted until one is authenticated,
+// answering a one-time code challenge when config has a TOTP secret
+func waitForLogin(ctx context.Context, config Config) error {
+	deadline := time.Now().Add(LOGIN_STEP_TIMEOUT)
+	codeSent := false
+
+	for {
+		var page struct {
+			HTML  string `json:"html"`
+			Ready string `json:"ready"`
+		}
+		err := chromedp.Run(ctx, chromedp.Evaluate(`({html: document.documentElement.outerHTML, ready: document.readyState})`, &page))
+		if err != nil {
+			return err
+		}
+
+		state, detail := classifyLoginPage(page.HTML)
+		switch state {
+		case pageAuthenticated:
+			if page.Ready == "complete" {
+				return nil
+			}
+		case pageError:
+			return fmt.Errorf("%w: %s", ErrLoginFailed, detail)
+		case pageInterstitial:
+			return fmt.Errorf("%w: %s; accept it once in a browser", ErrInterstitial, detail)
+		case pageMFA:
+			if config.TOTPSecret == "" {
+				return fmt.Errorf("%w; set mls.totp_secret to answer it", ErrMFARequired)
+			}
+			if !codeSent {
+				code, err := totpCode(config.TOTPSecret, time.Now())
+				if err != nil {
+					return err
+				}
+				if err := chromedp.Run(ctx, chromedp.SendKeys(detail, code+kb.Enter, chromedp.ByQuery)); err != nil {
+					return err
+				}
+				codeSent = true
+				deadline = time.Now().Add(LOGIN_STEP_TIMEOUT)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			switch state {
+			case pageMFA:
+				return fmt.Errorf("%w: the one-time code was not accepted", ErrLoginFailed)
+			case pageLogin:
+				return fmt.Errorf("%w: still on the login page after %v", ErrLoginFailed, LOGIN_STEP_TIMEOUT)
+			default:
+				return fmt.Errorf("%w: page did not finish loading after %v", ErrLoginFailed, LOGIN_STEP_TIMEOUT)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(LOGIN_POLL_INTERVAL):
+		}
+	}
+}
+
+var (
+	otpField        = regexp.MustCompile(`(?i)otp|one.?time|mfa|2fa|two.?factor|verification|passcode|auth.?code|security.?code`)
+	agreementButton = regexp.MustCompile(`(?i)^\s*(i\s+)?(agree|accept)`)
+	agreementText   = regexp.MustCompile(`(?i)license agreement|terms of (use|service)|acceptable use`)
+)
+
+// classifyLoginPage works out where a login has got to from the page's HTML.
+// detail is the error message for pageError, a selector for the code field for pageMFA
+// and the page title for pageInterstitial.
+func classifyLoginPage(page string) (state loginPage, detail string) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return pageLogin, ""
+	}
+
+	var password, otp *html.Node
+	var errorText, title string
+	var agreeButton, agreementHeading bool
+
+	walk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.DataAtom {
+		case atom.Title:
+			title = strings.TrimSpace(textContent(n))
+		case atom.Input:
+			switch strings.ToLower(attr(n, "type")) {
+			case "password":
+				password = n
+			case "", "text", "tel", "number":
+				if attr(n, "autocomplete") == "one-time-code" || otpField.MatchString(attr(n, "name")+" "+attr(n, "id")) {
+					otp = n
+				}
+			case "submit", "button":
+				agreeButton = agreeButton || agreementButton.MatchString(attr(n, "value"))
+			}
+		case atom.Button:
+			agreeButton = agreeButton || agreementButton.MatchString(textContent(n))
+		case atom.H1, atom.H2, atom.H3:
+			agreementHeading = agreementHeading || agreementText.MatchString(textContent(n))
+		}
+
+		if errorText == "" && isErrorMessage(n) {
+			errorText = strings.Join(strings.Fields(textContent(n)), " ")
+		}
+	})
+
+	switch {
+	case otp != nil:
+		return pageMFA, fieldSelector(otp)
+	case password != nil && errorText != "":
+		return pageError, errorText
+	case password != nil:
+		return pageLogin, ""
+	case agreeButton && (agreementHeading || agreementText.MatchString(title)):
+		if title == "" {
+			title = "license agreement"
+		}
+		return pageInterstitial, title
+	default:
+		return pageAuthenticated, ""
+	}
+}
+
+// isErrorMessage reports whether n is a visible-looking error or alert with text in it
+func isErrorMessage(n *html.Node) bool {
+	if attr(n, "role") != "alert" && !hasClass(n, "error") && !hasClass(n, "alert-danger") && !hasClass(n, "login-error") {
+		return false
+	}
+	if strings.Contains(strings.ReplaceAll(attr(n, "style"), " ", ""), "display:none") || hasAttr(n, "hidden") {
+		return false
+	}
+	return strings.TrimSpace(textContent(n)) != ""
+}
+
+// fieldSelector returns a CSS selector for an input, by id or name
+func fieldSelector(n *html.Node) string {
+	if id := attr(n, "id"); id != "" {
+		return fmt.Sprintf(`input[id=%q]`, id)
+	}
+	if name := attr(n, "name"); name != "" {
+		return fmt.Sprintf(`input[name=%q]`, name)
+	}
+	return `input[autocomplete="one-time-code"]`
+}
+
+func walk(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walk(child, fn)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode returns the 6 digit RFC 6238 code for a base32 secret at t
+func totpCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1_000_000), nil
+}
+
+// decodeTOTPSecret reads a base32 secret as shown by authenticator setup pages,
+// ignoring case, spaces and padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("TOTP secret is not valid base32")
+	}
+	return key, nil
+}
+
+// ValidateTOTPSecret checks a configured TOTP secret can be used
+func ValidateTOTPSecret(secret string) error {
+	_, err := decodeTOTPSecret(secret)
+	return err
+}
@@ -0,0 +1,104 @@
+package mls
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassifyLoginPage(t *testing.T) {
+	tests := []struct {
+		name   string
+		page   string
+		state  loginPage
+		detail string
+	}{
+		{
+			"login form",
+			`<form><input name="username"><input name="password" type="password"><div class="error"></div></form>`,
+			pageLogin, "",
+		},
+		{
+			"wrong password",
+			`<form><div class="alert alert-danger">Invalid username
+				or password.</div><input name="username"><input name="password" type="password"></form>`,
+			pageError, "Invalid username or password.",
+		},
+		{
+			"hidden error",
+			`<form><p role="alert" style="display: none">Locked</p><input type="password"></form>`,
+			pageLogin, "",
+		},
+		{
+			"authenticator code",
+			`<form><label>Enter the code from your app</label><input type="text" name="otpCode"><button>Verify</button></form>`,
+			pageMFA, `input[name="otpCode"]`,
+		},
+		{
+			"one-time-code field",
+			`<form><input id="code" autocomplete="one-time-code" inputmode="numeric"></form>`,
+			pageMFA, `input[id="code"]`,
+		},
+		{
+			"license agreement",
+			`<html><head><title>FlexMLS License Agreement</title></head><body><p>...</p><input type="submit" value="I Agree"></body></html>`,
+			pageInterstitial, "FlexMLS License Agreement",
+		},
+		{
+			"terms heading",
+			`<h2>Terms of Use</h2><button type="submit">Accept</button>`,
+			pageInterstitial, "license agreement",
+		},
+		{
+			"dashboard",
+			`<html><head><title>Flexmls Web</title></head><body><nav>Search</nav><button>Accept cookies</button></body></html>`,
+			pageAuthenticated, "",
+		},
+	}
+	for _, test := range tests {
+		state, detail := classifyLoginPage(test.page)
+		if state != test.state || detail != test.detail {
+			t.Errorf("%s: classifyLoginPage = %v, %q, want %v, %q", test.name, state, detail, test.state, test.detail)
+		}
+	}
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		code, err := totpCode(secret, time.Unix(test.unix, 0))
+		if err != nil || code != test.want {
+			t.Errorf("totpCode at %d = %q, %v, want %q", test.unix, code, err, test.want)
+		}
+	}
+
+	// Secrets are accepted as shown by setup pages
+	if code, err := totpCode("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0)); err != nil || code != "287082" {
+		t.Errorf("spaced lower case secret = %q, %v", code, err)
+	}
+	if err := ValidateTOTPSecret("not base32!"); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	if err := newTestSession(t, server, "abc", nil).verify(context.Background()); err != nil {
+		t.Errorf("logged in session: %v", err)
+	}
+	if err := newTestSession(t, server, "expired", nil).verify(context.Background()); !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("logged out session error = %v, want ErrLoginFailed", err)
+	}
+}
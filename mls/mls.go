@@ -0,0 +1,427 @@
+// Package mls looks up listing history on FlexMLS, logging in with Chrome and
+// then reusing the browser's cookies over plain HTTP
+package mls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"for-sale-report/tracing"
+)
+
+const DEFAULT_LOGIN_URL = "https://cr.flexmls.com/"
+
+// The address being looked up is appended
+const DEFAULT_SEARCH_URL = "https://apps.flexmls.com/quick_launch/herald?callback=lookupCallback&_filter="
+
+// Replace {id} with Id and {mlsid} with MLS Id from the search result
+const DEFAULT_HISTORY_URL = "https://cr.flexmls.com/cgi-bin/mainmenu.cgi?cmd=srv%20srch_rs/detail/addr_hist.html&list_tech_id=x%27{id}%27&srch=Y&ma_search_list=x%27{mlsid}%27"
+
+const LOGIN_TIMEOUT = 600 * time.Second
+const REQUEST_TIMEOUT = 60 * time.Second
+const MAX_PAGE_SIZE = 10 << 20 // Larger pages are cut off
+
+var tracer = otel.Tracer("for-sale-report/mls")
+
+// Lookup errors wrap one of these so callers can tell why a lookup failed
+var (
+	ErrNoResults = errors.New("No results found")
+	ErrParse     = errors.New("Failed to parse MLS page")
+)
+
+// PageError is a failed lookup or login with the page FlexMLS returned, for debugging
+type PageError struct {
+	Err        error
+	URL        string
+	Page       []byte // HTML, or the response body for searches
+	Screenshot []byte // PNG of the whole page, only for logins; lookups don't use the browser
+}
+
+func (e *PageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// withPage attaches the page FlexMLS returned, if any, to err
+func withPage(err error, url string, page []byte) error {
+	if len(page) == 0 {
+		return err
+	}
+	return &PageError{Err: err, URL: url, Page: page}
+}
+
+// Config represents MLS-related configuration
+type Config struct {
+	User       string `toml:"user"`
+	Pass       string `toml:"pass"`
+	TOTPSecret string `toml:"totp_secret"` // Base32 secret for accounts with authenticator app MFA
+	TimeZone   string `toml:"time_zone"`   // IANA name of the market's time zone, e.g. America/Chicago; UTC when empty
+
+	// Endpoints, so other FlexMLS regions or mock servers can be used. Defaults when empty.
+	LoginURL   string `toml:"login_url"`
+	SearchURL  string `toml:"search_url"`
+	HistoryURL string `toml:"history_url"`
+
+	Browser BrowserConfig `toml:"browser"`
+	Cache   CacheConfig   `toml:"cache"`
+	Session SessionConfig `toml:"session"`
+}
+
+// WithDefaults returns a copy of config with empty endpoints set to their defaults
+func (config Config) WithDefaults() Config {
+	if config.LoginURL == "" {
+		config.LoginURL = DEFAULT_LOGIN_URL
+	}
+	if config.SearchURL == "" {
+		config.SearchURL = DEFAULT_SEARCH_URL
+	}
+	if config.HistoryURL == "" {
+		config.HistoryURL = DEFAULT_HISTORY_URL
+	}
+	return config
+}
+
+// Location returns the market's time zone, which listing history dates are in
+func (config Config) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", config.TimeZone)
+	}
+	return loc, nil
+}
+
+// Session holds the cookies of a browser logged in to FlexMLS and uses them
+// to look up listings over plain HTTP
+type Session struct {
+	client    *http.Client
+	cookies   []*network.Cookie // As read from the browser, for saving the session
+	userAgent string
+	config    Config
+	location  *time.Location // Of dates in listing history
+	cache     *Cache
+}
+
+// Login starts a browser, logs in to FlexMLS and closes it again, keeping its cookies for lookups.
+// Lookups use cache, which may be nil, before FlexMLS.
+func Login(ctx context.Context, config Config, cache *Cache) (mls *Session, err error) {
+	config = config.WithDefaults()
+
+	ctx, span := tracer.Start(ctx, "mls.login", trace.WithAttributes(attribute.String("url", config.LoginURL)))
+	defer tracing.End(span, &err)
+
+	// Set a timeout
+	ctx, cancelTimeout := context.WithTimeout(ctx, LOGIN_TIMEOUT)
+	defer cancelTimeout()
+
+	// Create context; the browser is only needed to log in
+	ctx, cancelAllocator := config.Browser.allocator(ctx)
+	defer cancelAllocator()
+	ctx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+
+	cookies, userAgent, err := loginAndGetCookies(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := newSession(config, cookies, userAgent, cache)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.verify(ctx); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+var jsonpWrapper = regexp.MustCompile(`^\s*[\w$.]+\(`)
+
+// verify checks FlexMLS accepts the session's cookies by making a search
+func (mls *Session) verify(ctx context.Context) error {
+	page, err := mls.get(ctx, mls.config.SearchURL+url.QueryEscape("1 Main St"))
+	if err != nil {
+		return fmt.Errorf("%w: test search failed: %v", ErrLoginFailed, err)
+	}
+	if !jsonpWrapper.Match(page) {
+		return fmt.Errorf("%w: searches are answered with a login page", ErrLoginFailed)
+	}
+	return nil
+}
+
+// newSession creates a session sending cookies, as read from the browser, with every request
+func newSession(config Config, cookies []*network.Cookie, userAgent string, cache *Cache) (*Session, error) {
+	location, err := config.Location()
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cookie := range cookies {
+		host := strings.TrimPrefix(cookie.Domain, ".")
+		httpCookie := &http.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HTTPOnly,
+		}
+		// A leading dot means the cookie is shared with subdomains; otherwise it is for that host only
+		if strings.HasPrefix(cookie.Domain, ".") {
+			httpCookie.Domain = host
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: "/"}, []*http.Cookie{httpCookie})
+	}
+
+	// Lookups go through the browser's proxy too, since sessions may be tied to an IP address
+	client := &http.Client{Jar: jar, Timeout: REQUEST_TIMEOUT}
+	if proxy, err := config.Browser.ProxyURL(); err != nil {
+		return nil, err
+	} else if proxy != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		client.Transport = transport
+	}
+
+	return &Session{
+		client:    client,
+		cookies:   cookies,
+		userAgent: userAgent,
+		config:    config,
+		location:  location,
+		cache:     cache,
+	}, nil
+}
+
+// get fetches a page with the session's cookies. The page is returned with the error
+// when FlexMLS answers with an error status.
+func (mls *Session) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if mls.userAgent != "" {
+		req.Header.Set("User-Agent", mls.userAgent)
+	}
+
+	res, err := mls.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	page, err := io.ReadAll(io.LimitReader(res.Body, MAX_PAGE_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("MLS returned %s", res.Status)
+	}
+	return page, nil
+}
+
+// Gets the list of dates the address has been listed
+func (mls *Session) mostRecentlySold(ctx context.Context, id string, mlsId string) (sold time.Time, err error) {
+	url := mls.config.HistoryURL
+	url = strings.Replace(url, "{id}", id, 1)
+	url = strings.Replace(url, "{mlsid}", mlsId, 1)
+
+	ctx, span := tracer.Start(ctx, "mls.history", trace.WithAttributes(attribute.String("id", id), attribute.String("mls_id", mlsId)))
+	defer tracing.End(span, &err)
+
+	page, err := mls.get(ctx, url)
+	if err == nil {
+		sold, err = parseHistory(page, mls.location)
+	}
+	if err != nil {
+		return time.Time{}, withPage(err, url, page)
+	}
+	return sold, nil
+}
+
+// parseHistory returns the most recent date in a listing history page, in the market's time zone loc
+func parseHistory(page []byte, loc *time.Location) (time.Time, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	// The first date in the history table is the most recent
+	date, ok := firstDateCell(doc)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: No dates in listing history", ErrParse)
+	}
+
+	return parseHistoryDate(date, loc)
+}
+
+// firstDateCell returns the text of the first `tbody tr td.date` in the page
+func firstDateCell(n *html.Node) (string, bool) {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Td && hasClass(n, "date") && inTableBody(n) {
+		return strings.TrimSpace(textContent(n)), true
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if text, ok := firstDateCell(child); ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "class" && slices.Contains(strings.Fields(attr.Val), class) {
+			return true
+		}
+	}
+	return false
+}
+
+func inTableBody(n *html.Node) bool {
+	for parent := n.Parent; parent != nil; parent = parent.Parent {
+		if parent.DataAtom == atom.Tbody {
+			return true
+		}
+	}
+	return false
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(textContent(child))
+	}
+	return sb.String()
+}
+
+// Match is the MLS listing found for an address
+type Match struct {
+	ID     string    `json:"id"`
+	MlsID  string    `json:"mlsId"`
+	SoldAt time.Time `json:"soldAt"` // Most recent date in the listing history
+}
+
+// Lookup finds the listing for addr and the most recent date in its history
+func (mls *Session) Lookup(ctx context.Context, addr string) (match *Match, err error) {
+	ctx, span := tracer.Start(ctx, "mls.Lookup", trace.WithAttributes(attribute.String("address", addr)))
+	defer tracing.End(span, &err)
+
+	/*
+	 * First, get the Id & MlsId from the address
+	 */
+	match, ok := mls.cache.search(addr)
+	span.SetAttributes(attribute.Bool("search_cached", ok))
+	if !ok {
+		match, err = mls.search(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		mls.cache.putSearch(addr, match)
+	}
+
+	/*
+	 * Second, use Id & MlsId to find when the address was last listed
+	 */
+	soldAt, ok := mls.cache.history(match)
+	span.SetAttributes(attribute.Bool("history_cached", ok))
+	if ok {
+		match.SoldAt = soldAt
+		return match, nil
+	}
+	match.SoldAt, err = mls.mostRecentlySold(ctx, match.ID, match.MlsID)
+	if err != nil {
+		return nil, err
+	}
+	mls.cache.putHistory(match)
+	return match, nil
+}
+
+// search finds the Id and MlsId of the listing for addr with quick_launch
+func (mls *Session) search(ctx context.Context, addr string) (match *Match, err error) {
+	ctx, span := tracer.Start(ctx, "mls.search")
+	defer tracing.End(span, &err)
+
+	// setup URL
+	searchURL := mls.config.SearchURL + url.QueryEscape(strings.ReplaceAll(addr, ",", ""))
+
+	// fetch raw json
+	page, err := mls.get(ctx, searchURL)
+	if err == nil {
+		match, err = parseSearch(page, addr)
+	}
+	if err != nil {
+		return nil, withPage(err, searchURL, page)
+	}
+	return match, nil
+}
+
+func parseSearch(page []byte, addr string) (*Match, error) {
+	jsonString := string(page)
+
+	// Strip out the `lookupCallback(...)` wrapper to extract the raw json
+	prefix := "lookupCallback("
+	suffix := ")"
+
+	start := strings.Index(jsonString, prefix)
+	end := strings.LastIndex(jsonString, suffix)
+	if start == -1 || end == -1 {
+		return nil, fmt.Errorf("%w: Invalid JSON wrapper", ErrParse)
+	}
+
+	cleanJSON := jsonString[start+len(prefix) : end]
+
+	// Expected json
+	type LookupResponse struct {
+		D struct {
+			Results []struct {
+				Id    string `json:"Id"`
+				MlsId string `json:"MlsId"`
+			} `json:"Results"`
+		} `json:"D"`
+	}
+
+	var data LookupResponse
+	err := json.Unmarshal([]byte(cleanJSON), &data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Failed to parse JSON: %v", ErrParse, err)
+	}
+
+	// If no results, it failed
+	if len(data.D.Results) == 0 {
+		return nil, fmt.Errorf("%w - %s", ErrNoResults, addr)
+	}
+
+	return &Match{ID: data.D.Results[0].Id, MlsID: data.D.Results[0].MlsId}, nil
+}
+
+// Close releases the session's connections
+func (mls *Session) Close() {
+	mls.client.CloseIdleConnections()
+}
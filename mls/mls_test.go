@@ -0,0 +1,173 @@
+package mls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// newTestServer serves quick_launch results for 1 Main St and its listing history,
+// to requests carrying the session cookie
+func newTestServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" || r.UserAgent() != "TestBrowser/1.0" {
+			fmt.Fprint(w, `<html><body><form><input name="username"></form></body></html>`)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/search":
+			switch r.URL.Query().Get("_filter") {
+			case "1 Main St Springfield":
+				fmt.Fprint(w, `lookupCallback({"D":{"Results":[{"Id":"20001","MlsId":"M-1"}]}})`)
+			default:
+				fmt.Fprint(w, `lookupCallback({"D":{"Results":[]}})`)
+			}
+		case "/history":
+			if r.URL.Query().Get("id") != "20001" || r.URL.Query().Get("mlsid") != "M-1" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `<html><body><table>
+				<thead><tr><td class="date">Date</td></tr></thead>
+				<tbody>
+					<tr><td class="status">Sold</td><td class="date"> 03/14/2025 </td></tr>
+					<tr><td class="status">Listed</td><td class="date">01/02/2025</td></tr>
+				</tbody>
+			</table></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func testConfig(server *httptest.Server) Config {
+	return Config{
+		User:       "user",
+		Pass:       "pass",
+		SearchURL:  server.URL + "/search?callback=lookupCallback&_filter=",
+		HistoryURL: server.URL + "/history?id={id}&mlsid={mlsid}",
+	}.WithDefaults()
+}
+
+func testCookies(server *httptest.Server, value string) []*network.Cookie {
+	host, _ := url.Parse(server.URL)
+	return []*network.Cookie{{Name: "session", Value: value, Domain: host.Hostname(), Path: "/", Session: true}}
+}
+
+func newTestSession(t *testing.T, server *httptest.Server, cookieValue string, cache *Cache) *Session {
+	t.Helper()
+	session, err := newSession(testConfig(server), testCookies(server, cookieValue), "TestBrowser/1.0", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Close)
+	return session
+}
+
+func TestLookup(t *testing.T) {
+	server, requests := newTestServer(t)
+	session := newTestSession(t, server, "abc", nil)
+
+	match, err := session.Lookup(context.Background(), "1 Main St, Springfield")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+	if match.ID != "20001" || match.MlsID != "M-1" || !match.SoldAt.Equal(want) {
+		t.Errorf("Lookup = %+v, want 20001/M-1 sold %v", match, want)
+	}
+	if fmt.Sprint(requests()) != "[/search /history]" {
+		t.Errorf("requests = %v", requests())
+	}
+
+	if _, err := session.Lookup(context.Background(), "2 Main St, Springfield"); !errors.Is(err, ErrNoResults) {
+		t.Errorf("unknown address error = %v, want ErrNoResults", err)
+	}
+}
+
+func TestLookupInMarketTimeZone(t *testing.T) {
+	server, _ := newTestServer(t)
+	config := testConfig(server)
+	config.TimeZone = "America/New_York"
+	session, err := newSession(config, testCookies(server, "abc"), "TestBrowser/1.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	match, err := session.Lookup(context.Background(), "1 Main St, Springfield")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 3, 14, 4, 0, 0, 0, time.UTC); !match.SoldAt.Equal(want) {
+		t.Errorf("SoldAt = %v, want midnight in New York (%v)", match.SoldAt, want)
+	}
+}
+
+func TestLookupWithoutSession(t *testing.T) {
+	server, _ := newTestServer(t)
+	session := newTestSession(t, server, "expired", nil)
+
+	// The login page comes back instead of results
+	_, err := session.Lookup(context.Background(), "1 Main St, Springfield")
+	if !errors.Is(err, ErrParse) {
+		t.Errorf("Lookup error = %v, want ErrParse", err)
+	}
+
+	// The page is kept for debugging
+	var pageErr *PageError
+	if !errors.As(err, &pageErr) {
+		t.Fatalf("Lookup error = %v, want a *PageError", err)
+	}
+	if !strings.Contains(string(pageErr.Page), `name="username"`) || !strings.Contains(pageErr.URL, "/search") || pageErr.Screenshot != nil {
+		t.Errorf("PageError = %s %q", pageErr.URL, pageErr.Page)
+	}
+}
+
+func TestLookupCached(t *testing.T) {
+	server, requests := newTestServer(t)
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "mls_cache.json"), CacheConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := newTestSession(t, server, "abc", cache)
+
+	for _, addr := range []string{"1 Main St, Springfield", "1 MAIN STREET Springfield"} {
+		match, err := session.Lookup(context.Background(), addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match.MlsID != "M-1" || match.SoldAt.IsZero() {
+			t.Errorf("Lookup(%q) = %+v", addr, match)
+		}
+	}
+	if got := strings.Join(requests(), " "); got != "/search /history" {
+		t.Errorf("requests = %s, want one search and one history page", got)
+	}
+}
@@ -0,0 +1,192 @@
+package mls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+
+	"for-sale-report/internal/atomicfile"
+)
+
+// SessionConfig controls saving the logged in session between runs
+type SessionConfig struct {
+	Disabled bool   `toml:"disabled"`
+	Key      string `toml:"key"` // Secret the saved session is encrypted with, derived from user and pass when empty
+}
+
+// savedSession is what is encrypted to disk
+type savedSession struct {
+	Cookies   []savedCookie `json:"cookies"`
+	UserAgent string        `json:"userAgent"`
+	SavedAt   time.Time     `json:"savedAt"`
+}
+
+// savedCookie is the part of a browser cookie needed to send it again
+type savedCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"` // Seconds since the UNIX epoch
+	Session  bool    `json:"session"` // Expires with the browser, so Expires is unset
+	Secure   bool    `json:"secure"`
+	HTTPOnly bool    `json:"httpOnly"`
+}
+
+func saveCookies(cookies []*network.Cookie) []savedCookie {
+	saved := make([]savedCookie, 0, len(cookies))
+	for _, c := range cookies {
+		saved = append(saved, savedCookie{c.Name, c.Value, c.Domain, c.Path, c.Expires, c.Session, c.Secure, c.HTTPOnly})
+	}
+	return saved
+}
+
+func (saved *savedSession) browserCookies() []*network.Cookie {
+	cookies := make([]*network.Cookie, 0, len(saved.Cookies))
+	for _, c := range saved.Cookies {
+		cookies = append(cookies, &network.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Session:  c.Session,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+		})
+	}
+	return cookies
+}
+
+// loginWithBrowser is replaced in tests, which have no browser
+var loginWithBrowser = Login
+
+// Resume reuses the session saved at path when FlexMLS still accepts it, and otherwise
+// logs in and saves the new session there. Saving is skipped when disabled in config.
+func Resume(ctx context.Context, config Config, cache *Cache, path string) (*Session, error) {
+	config = config.WithDefaults()
+	if config.Session.Disabled {
+		return loginWithBrowser(ctx, config, cache)
+	}
+
+	key, err := sessionKey(config)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := loadSession(path, key)
+	if err != nil {
+		slog.WarnContext(ctx, "Ignoring saved MLS session", "error", err)
+	}
+	if saved != nil {
+		session, err := newSession(config, saved.browserCookies(), saved.UserAgent, cache)
+		if err != nil {
+			return nil, err
+		}
+		err = session.verify(ctx)
+		if err == nil {
+			slog.InfoContext(ctx, "Reusing saved MLS session", "saved_at", saved.SavedAt)
+			return session, nil
+		}
+		slog.InfoContext(ctx, "Saved MLS session is no longer valid, logging in", "error", err)
+	}
+
+	session, err := loginWithBrowser(ctx, config, cache)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveSession(path, key, savedSession{Cookies: saveCookies(session.cookies), UserAgent: session.userAgent, SavedAt: time.Now()}); err != nil {
+		slog.WarnContext(ctx, "Failed to save MLS session", "error", err)
+	}
+	return session, nil
+}
+
+// sessionKey derives the AES-256 key for the saved session
+func sessionKey(config Config) ([]byte, error) {
+	secret := config.Session.Key
+	if secret == "" {
+		secret = config.User + "\x00" + config.Pass
+	}
+	return hkdf.Key(sha256.New, []byte(secret), nil, "for-sale-report mls session", 32)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadSession decrypts the session saved at path, dropping expired cookies.
+// It returns nil when there is no saved session or none of its cookies are left.
+func loadSession(path string, key []byte) (*savedSession, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MLS session: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("MLS session %s is truncated", path)
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt MLS session %s; was the key or password changed?", path)
+	}
+
+	var saved savedSession
+	if err := json.Unmarshal(plain, &saved); err != nil {
+		return nil, fmt.Errorf("failed to read MLS session %s: %w", path, err)
+	}
+
+	now := float64(time.Now().Unix())
+	saved.Cookies = slices.DeleteFunc(saved.Cookies, func(cookie savedCookie) bool {
+		return !cookie.Session && cookie.Expires > 0 && cookie.Expires < now
+	})
+	if len(saved.Cookies) == 0 {
+		return nil, nil
+	}
+	return &saved, nil
+}
+
+// saveSession encrypts saved to path, readable only by the owner
+func saveSession(path string, key []byte, saved savedSession) error {
+	plain, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := gcm.Seal(nonce, nonce, plain, nil)
+
+	if err := atomicfile.Write(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save MLS session: %w", err)
+	}
+	return nil
+}
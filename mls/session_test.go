@@ -0,0 +1,110 @@
+package mls
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSavedSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mls_session.enc")
+	key, err := sessionKey(Config{User: "user", Pass: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := float64(time.Now().Add(-time.Hour).Unix())
+	err = saveSession(path, key, savedSession{
+		Cookies: []savedCookie{
+			{Name: "session", Value: "abc", Session: true},
+			{Name: "old", Value: "1", Expires: expired},
+		},
+		UserAgent: "TestBrowser/1.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cookie values aren't readable on disk
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 || bytes.Contains(data, []byte("abc")) || bytes.Contains(data, []byte("TestBrowser")) {
+		t.Errorf("saved session is readable: mode %v", info.Mode())
+	}
+
+	saved, err := loadSession(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Cookies) != 1 || saved.Cookies[0].Value != "abc" || saved.UserAgent != "TestBrowser/1.0" {
+		t.Errorf("loaded %+v", saved)
+	}
+
+	// A changed password can't read it
+	otherKey, _ := sessionKey(Config{User: "user", Pass: "new pass"})
+	if _, err := loadSession(path, otherKey); err == nil {
+		t.Error("session decrypted with another key")
+	}
+
+	if saved, err := loadSession(filepath.Join(t.TempDir(), "missing.enc"), key); saved != nil || err != nil {
+		t.Errorf("missing session = %v, %v", saved, err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "mls_session.enc")
+	config := testConfig(server)
+
+	logins := 0
+	cookie := "abc"
+	original := loginWithBrowser
+	loginWithBrowser = func(ctx context.Context, config Config, cache *Cache) (*Session, error) {
+		logins++
+		return newSession(config, testCookies(server, cookie), "TestBrowser/1.0", cache)
+	}
+	t.Cleanup(func() { loginWithBrowser = original })
+
+	resume := func() *Session {
+		t.Helper()
+		session, err := Resume(context.Background(), config, nil, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.Lookup(context.Background(), "1 Main St, Springfield"); err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	// The first run logs in and saves the session; the next reuses it
+	resume()
+	resume()
+	if logins != 1 {
+		t.Errorf("logged in %d times, want 1", logins)
+	}
+
+	// A session FlexMLS no longer accepts is replaced
+	key, _ := sessionKey(config)
+	if err := saveSession(path, key, savedSession{Cookies: saveCookies(testCookies(server, "expired")), UserAgent: "TestBrowser/1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	resume()
+	resume()
+	if logins != 2 {
+		t.Errorf("logged in %d times, want 2", logins)
+	}
+
+	// Saving can be turned off
+	config.Session.Disabled = true
+	os.Remove(path)
+	resume()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("session saved while disabled")
+	}
+}
@@ -0,0 +1,236 @@
+// Package report builds and emails the sold listings report
+package report
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/smtp"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"for-sale-report/fub"
+	"for-sale-report/tracing"
+)
+
+var tracer = otel.Tracer("for-sale-report/report")
+
+// Config represents SMTP-related configuration, shared by all tenants
+type Config struct {
+	User string `toml:"user"`
+	Pass string `toml:"pass"`
+	From string `toml:"from"`
+	Host string `toml:"host"`
+	Port string `toml:"port"`
+
+	// TLSConfig is used for STARTTLS; verifies against Host when nil
+	TLSConfig *tls.Config `toml:"-"`
+}
+
+// Mailer sends reports through a single SMTP server
+type Mailer struct {
+	config Config
+}
+
+func NewMailer(config Config) *Mailer {
+	return &Mailer{config}
+}
+
+func (m *Mailer) tlsConfig() *tls.Config {
+	if m.config.TLSConfig != nil {
+		return m.config.TLSConfig
+	}
+	return &tls.Config{ServerName: m.config.Host}
+}
+
+// Verify checks if the SMTP server can be accessed and authenticated
+func (m *Mailer) Verify() error {
+	host := m.config.Host
+	addr := fmt.Sprintf("%s:%s", host, m.config.Port)
+
+	// Connect to the SMTP server
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return fmt.Errorf("failed to get data writer: %w", err)
+	}
+	defer client.Close()
+
+	// Upgrade connection to TLS
+	if err = client.StartTLS(m.tlsConfig()); err != nil {
+		return fmt.Errorf("failed to start TLS: %w", err)
+	}
+
+	// Check if server supports AUTH extension
+	if ok, auths := client.Extension("AUTH"); ok {
+		slog.Debug("SMTP server supports authentication", "mechanisms", auths)
+	} else {
+		return fmt.Errorf("SMTP server does not support authentication")
+	}
+
+	// Authenticate using PlainAuth
+	auth := smtp.PlainAuth("", m.config.User, m.config.Pass, host)
+	if err := client.Auth(auth); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+
+	slog.Debug("Authenticated with SMTP server")
+	return nil
+}
+
+// Introductions for the report, depending on what the run did in FUB
+const (
+	INTRO_TAGGED   = "The following individuals have been marked as expired leads in FUB. If no individuals are listed below, then all leads are still valid."
+	INTRO_REPORTED = "The following individuals appear to have sold. FUB has not been changed. If no individuals are listed below, then all leads are still valid."
+	INTRO_REVIEW   = "The following individuals appear to have sold and are waiting for approval before they are marked as expired leads in FUB. If no individuals are listed below, then all leads are still valid."
+)
+
+// Failure is a lookup that failed, listed in the report with the debug files saved for it
+type Failure struct {
+	PersonID int
+	Name     string
+	Address  string
+	Error    string
+	Files    []string // The response page saved for it, when debug artifacts are enabled; only logins have screenshots
+}
+
+// Build HTML body from ListedPerson slice, followed by any failed lookups
+func buildHTMLBody(intro string, people []fub.ListedPerson, failures []Failure) string {
+	var sb strings.Builder
+	sb.WriteString(`<html><body>`)
+	sb.WriteString(`<h2>Listings Report</h2>`)
+	sb.WriteString(`<p>` + intro + `</p>`)
+	sb.WriteString(`<table border="1" cellpadding="5" cellspacing="0" style="border-collapse: collapse; width: 100%;">`)
+	sb.WriteString(`<tr style="background-color: #dddddd;"><th>#</th><th>Name</th><th>ID</th><th>Addresses</th><th>Smart Lists</th></tr>`)
+
+	for i, p := range people {
+		// Alternate row background color
+		rowColor := "#ffffff"
+		if i%2 == 1 {
+			rowColor = "#f2f2f2"
+		}
+
+		addresses := []string{}
+		for _, a := range p.Addresses {
+			addresses = append(addresses, html.EscapeString(fmt.Sprintf("%s, %s, %s %s", a.Street, a.City, a.State, a.Code)))
+		}
+		smartLists := []string{}
+		for _, name := range p.SmartListNames() {
+			smartLists = append(smartLists, html.EscapeString(name))
+		}
+
+		sb.WriteString(fmt.Sprintf(
+			`<tr style="background-color: %s;">
+				<td>%d</td>
+				<td><b>%s</b></td>
+				<td>%d</td>
+				<td>%s</td>
+				<td>%s</td>
+			</tr>`,
+			rowColor,
+			i+1,
+			html.EscapeString(p.Name),
+			p.ID,
+			strings.Join(addresses, "<br>"),
+			strings.Join(smartLists, "<br>"),
+		))
+	}
+
+	sb.WriteString(`</table>`)
+
+	if len(failures) > 0 {
+		sb.WriteString(`<h3>Failed Lookups</h3>`)
+		sb.WriteString(`<table border="1" cellpadding="5" cellspacing="0" style="border-collapse: collapse; width: 100%;">`)
+		sb.WriteString(`<tr style="background-color: #dddddd;"><th>ID</th><th>Name</th><th>Address</th><th>Error</th><th>Files</th></tr>`)
+		for _, f := range failures {
+			files := make([]string, 0, len(f.Files))
+			for _, file := range f.Files {
+				files = append(files, `<code>`+html.EscapeString(file)+`</code>`)
+			}
+			sb.WriteString(fmt.Sprintf(
+				`<tr><td>%d</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				f.PersonID,
+				html.EscapeString(f.Name),
+				html.EscapeString(f.Address),
+				html.EscapeString(f.Error),
+				strings.Join(files, "<br>"),
+			))
+		}
+		sb.WriteString(`</table>`)
+	}
+
+	sb.WriteString(`</body></html>`)
+	return sb.String()
+}
+
+// Send emails the HTML report to multiple recipients, opening with one of the INTRO_ texts.
+// failures are listed after the people, and left out when empty.
+func (m *Mailer) Send(ctx context.Context, subject string, to []string, intro string, people []fub.ListedPerson, failures []Failure) (err error) {
+	_, span := tracer.Start(ctx, "smtp.send", trace.WithAttributes(
+		attribute.Int("recipients", len(to)),
+		attribute.Int("people", len(people)),
+		attribute.Int("failures", len(failures)),
+	))
+	defer tracing.End(span, &err)
+
+	if m.config.User == "" || len(to) == 0 {
+		return fmt.Errorf("SMTP config not initialized properly")
+	}
+
+	host := m.config.Host
+	port := m.config.Port
+	addr := fmt.Sprintf("%s:%s", host, port)
+
+	body := buildHTMLBody(intro, people, failures)
+
+	// Construct MIME email with HTML
+	msg := fmt.Sprintf("From: %s\r\n", m.config.From)
+	msg += fmt.Sprintf("To: %s\r\n", strings.Join(to, ","))
+	msg += fmt.Sprintf("Subject: %s\r\n", subject)
+	msg += "MIME-Version: 1.0\r\n"
+	msg += "Content-Type: text/html; charset=\"UTF-8\"\r\n"
+	msg += "\r\n" + body
+
+	auth := smtp.PlainAuth("", m.config.User, m.config.Pass, host)
+
+	// Connect
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	// Upgrade to TLS
+	if err = client.StartTLS(m.tlsConfig()); err != nil {
+		return fmt.Errorf("failed to start TLS: %w", err)
+	}
+
+	// Authenticate
+	if err = client.Auth(auth); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+
+	// Send to multiple recipients
+	if err = client.Mail(m.config.From); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = wc.Write([]byte(msg))
+	if err != nil {
+		return err
+	}
+	return wc.Close()
+}
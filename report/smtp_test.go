@@ -0,0 +1,101 @@
+package report
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/fakesmtp"
+)
+
+func newTestMailer(t *testing.T, pass string) (*Mailer, *fakesmtp.Server) {
+	t.Helper()
+	server, err := fakesmtp.New("mailer", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return NewMailer(Config{
+		User:      "mailer",
+		Pass:      pass,
+		From:      "reports@example.com",
+		Host:      server.Host(),
+		Port:      server.Port(),
+		TLSConfig: server.ClientTLSConfig(),
+	}), server
+}
+
+func TestVerify(t *testing.T) {
+	mailer, _ := newTestMailer(t, "secret")
+	if err := mailer.Verify(); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+
+	mailer, _ = newTestMailer(t, "wrong")
+	if err := mailer.Verify(); err == nil {
+		t.Error("Verify() should fail with the wrong password")
+	}
+}
+
+func TestSend(t *testing.T) {
+	mailer, server := newTestMailer(t, "secret")
+
+	people := []fub.ListedPerson{
+		{
+			Person:     fub.Person{ID: 1, Name: "Ada", Addresses: []fub.PersonAddress{{Street: "1 Main St", City: "Springfield", State: "IL", Code: "62701"}}},
+			SmartLists: []fub.SmartList{{ID: 3, Name: "Past Sellers"}, {ID: 4, Name: "Expired & <Withdrawn>"}},
+		},
+		{Person: fub.Person{ID: 2, Name: "Grace <Hopper>"}},
+	}
+	if err := mailer.Send(context.Background(), "Sold Listings", []string{"a@example.com", "b@example.com"}, INTRO_REVIEW, people, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "reports@example.com" || strings.Join(msg.To, ",") != "a@example.com,b@example.com" {
+		t.Errorf("envelope = %s -> %v", msg.From, msg.To)
+	}
+	for _, want := range []string{"Subject: Sold Listings", "Content-Type: text/html", "waiting for approval", "<b>Ada</b>", "1 Main St, Springfield, IL 62701", "<b>Grace &lt;Hopper&gt;</b>", "Past Sellers<br>Expired &amp; &lt;Withdrawn&gt;"} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("message missing %q", want)
+		}
+	}
+	if strings.Contains(msg.Data, "Failed Lookups") {
+		t.Error("message lists failed lookups when there are none")
+	}
+}
+
+func TestSendListsFailures(t *testing.T) {
+	mailer, server := newTestMailer(t, "secret")
+
+	failures := []Failure{{
+		PersonID: 7,
+		Name:     "Ada",
+		Address:  "1 Main St",
+		Error:    "parse error: <no dates>",
+		Files:    []string{"data/default/artifacts/run/7.html", "data/default/artifacts/run/7.png"},
+	}}
+	if err := mailer.Send(context.Background(), "Sold Listings", []string{"a@example.com"}, INTRO_TAGGED, nil, failures); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := server.Messages()[0]
+	for _, want := range []string{"Failed Lookups", "<td>7</td>", "parse error: &lt;no dates&gt;", "<code>data/default/artifacts/run/7.html</code>", "data/default/artifacts/run/7.png"} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("message missing %q", want)
+		}
+	}
+}
+
+func TestSendRequiresRecipients(t *testing.T) {
+	mailer, _ := newTestMailer(t, "secret")
+	if err := mailer.Send(context.Background(), "Sold Listings", nil, INTRO_TAGGED, nil, nil); err == nil {
+		t.Error("expected an error without recipients")
+	}
+}
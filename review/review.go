@@ -0,0 +1,175 @@
+// Package review holds the people flagged by review mode runs until they are approved or rejected
+package review
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"for-sale-report/fub"
+	"for-sale-report/internal/atomicfile"
+	"for-sale-report/mls"
+)
+
+// Item is one flagged person and the changes that approving them would make
+type Item struct {
+	Person    fub.ListedPerson `json:"person"`
+	Update    fub.Update       `json:"update"`
+	Match     *mls.Match       `json:"match,omitempty"` // The sale they were flagged for
+	RunID     string           `json:"runId"`
+	FlaggedAt time.Time        `json:"flaggedAt"`
+}
+
+// Rejection remembers a rejected person so they aren't flagged again for the same sale
+type Rejection struct {
+	PersonID   int        `json:"personId"`
+	Match      *mls.Match `json:"match,omitempty"`
+	RejectedAt time.Time  `json:"rejectedAt"`
+}
+
+// List is the set of people waiting for review, saved to disk after every change
+type List struct {
+	mu   sync.Mutex
+	path string
+	file listFile
+}
+
+// listFile is what is saved to disk
+type listFile struct {
+	Items    []Item      `json:"items"`
+	Rejected []Rejection `json:"rejected,omitempty"`
+}
+
+// Open loads the list saved at path, which may not exist yet
+func Open(path string) (*List, error) {
+	l := &List{path: path, file: listFile{Items: make([]Item, 0)}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review list: %w", err)
+	}
+
+	// Lists saved before rejections were remembered are just the items
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &l.file.Items)
+	} else {
+		err = json.Unmarshal(data, &l.file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review list %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// sameMatch reports whether two matches are the same sale of the same listing
+func sameMatch(a, b *mls.Match) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && a.MlsID == b.MlsID && a.SoldAt.Equal(b.SoldAt)
+}
+
+// Items returns every person waiting for review, oldest first
+func (l *List) Items() []Item {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.file.Items)
+}
+
+// Add flags item for review. A person already on the list is replaced by the newer item,
+// keeping their place in the list. A person rejected for the same match isn't added again;
+// a different match, such as a later sale, forgets the rejection.
+func (l *List) Add(item Item) (added bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rejected := slices.IndexFunc(l.file.Rejected, func(r Rejection) bool { return r.PersonID == item.Person.ID })
+	if rejected != -1 {
+		if sameMatch(l.file.Rejected[rejected].Match, item.Match) {
+			return false, nil
+		}
+		l.file.Rejected = slices.Delete(l.file.Rejected, rejected, rejected+1)
+	}
+
+	if item.FlaggedAt.IsZero() {
+		item.FlaggedAt = time.Now()
+	}
+
+	index := slices.IndexFunc(l.file.Items, func(existing Item) bool { return existing.Person.ID == item.Person.ID })
+	if index == -1 {
+		l.file.Items = append(l.file.Items, item)
+	} else {
+		l.file.Items[index] = item
+	}
+	return true, l.save()
+}
+
+// Reject takes the items for the given person IDs, or every item when ids is empty, like Take,
+// and remembers them so later runs don't flag them again for the same match
+func (l *List) Reject(ids []int) (rejected []Item, missing []int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rejected, missing = l.take(ids)
+	now := time.Now()
+	for _, item := range rejected {
+		l.file.Rejected = slices.DeleteFunc(l.file.Rejected, func(r Rejection) bool { return r.PersonID == item.Person.ID })
+		l.file.Rejected = append(l.file.Rejected, Rejection{PersonID: item.Person.ID, Match: item.Match, RejectedAt: now})
+	}
+	return rejected, missing, l.save()
+}
+
+// Take removes and returns the items for the given person IDs, or every item when ids is empty.
+// IDs that aren't on the list are returned as missing.
+func (l *List) Take(ids []int) (taken []Item, missing []int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	taken, missing = l.take(ids)
+	return taken, missing, l.save()
+}
+
+func (l *List) take(ids []int) (taken []Item, missing []int) {
+	if len(ids) == 0 {
+		taken, l.file.Items = l.file.Items, make([]Item, 0)
+		return taken, nil
+	}
+
+	for _, id := range ids {
+		index := slices.IndexFunc(l.file.Items, func(item Item) bool { return item.Person.ID == id })
+		if index == -1 {
+			missing = append(missing, id)
+			continue
+		}
+		taken = append(taken, l.file.Items[index])
+		l.file.Items = slices.Delete(l.file.Items, index, index+1)
+	}
+	return taken, missing
+}
+
+// save writes the list to disk, removing the file when there is nothing waiting or rejected
+func (l *List) save() error {
+	if len(l.file.Items) == 0 && len(l.file.Rejected) == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove review list: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(l.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(l.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save review list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"for-sale-report/fub"
+	"for-sale-report/mls"
+)
+
+func newItem(id int, runID string) Item {
+	return Item{
+		Person: fub.ListedPerson{Person: fub.Person{ID: id, Name: fmt.Sprintf("Person %d", id)}},
+		Update: fub.Update{PersonID: id, AddTags: []string{fub.SOLD_TAG}},
+		RunID:  runID,
+	}
+}
+
+func ids(items []Item) string {
+	out := make([]int, 0, len(items))
+	for _, item := range items {
+		out = append(out, item.Person.ID)
+	}
+	return fmt.Sprint(out)
+}
+
+func TestListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_review.json")
+
+	list, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []Item{newItem(1, "run-1"), newItem(2, "run-1"), newItem(1, "run-2")} {
+		if _, err := list.Add(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// People flagged again are replaced in place
+	list, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := list.Items()
+	if ids(items) != "[1 2]" || items[0].RunID != "run-2" {
+		t.Fatalf("items = %+v", items)
+	}
+	if items[0].FlaggedAt.IsZero() {
+		t.Error("FlaggedAt was not set")
+	}
+}
+
+func TestListTake(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_review.json")
+	list, _ := Open(path)
+	for id := 1; id <= 3; id++ {
+		list.Add(newItem(id, "run-1"))
+	}
+
+	taken, missing, err := list.Take([]int{3, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(taken) != "[3]" || fmt.Sprint(missing) != "[7]" || ids(list.Items()) != "[1 2]" {
+		t.Errorf("Take([3 7]) = %v, %v leaving %v", ids(taken), missing, ids(list.Items()))
+	}
+
+	// No IDs takes everything and removes the file
+	taken, _, err = list.Take(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(taken) != "[1 2]" || len(list.Items()) != 0 {
+		t.Errorf("Take(nil) = %v leaving %v", ids(taken), ids(list.Items()))
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("review list file still exists: %v", err)
+	}
+}
+
+func TestListRemembersRejections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_review.json")
+	sold := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+	flag := func(list *List, id int, soldAt time.Time) bool {
+		t.Helper()
+		item := newItem(id, "run")
+		item.Match = &mls.Match{ID: "20001", MlsID: "M-1", SoldAt: soldAt}
+		added, err := list.Add(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+
+	list, _ := Open(path)
+	flag(list, 1, sold)
+	flag(list, 2, sold)
+	rejected, missing, err := list.Reject([]int{1, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(rejected) != "[1]" || fmt.Sprint(missing) != "[7]" || ids(list.Items()) != "[2]" {
+		t.Errorf("Reject([1 7]) = %v, %v leaving %v", ids(rejected), missing, ids(list.Items()))
+	}
+
+	// The rejection is saved, so the same sale isn't flagged again
+	list, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flag(list, 1, sold) {
+		t.Error("rejected person was flagged again for the same sale")
+	}
+	if ids(list.Items()) != "[2]" {
+		t.Errorf("items = %v, want [2]", ids(list.Items()))
+	}
+
+	// A later sale is flagged, and forgets the rejection
+	if !flag(list, 1, sold.AddDate(1, 0, 0)) || ids(list.Items()) != "[2 1]" {
+		t.Errorf("person with a new sale wasn't flagged, items = %v", ids(list.Items()))
+	}
+	list.Take([]int{1})
+	if !flag(list, 1, sold) {
+		t.Error("rejection was kept after a different match was flagged")
+	}
+}
+
+func TestOpenListWithoutRejections(t *testing.T) {
+	// Lists saved before rejections were remembered are a plain array of items
+	path := filepath.Join(t.TempDir(), "pending_review.json")
+	if err := os.WriteFile(path, []byte(`[{"person":{"id":4},"update":{"personId":4},"runId":"old"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list.Items(); ids(items) != "[4]" || items[0].RunID != "old" {
+		t.Errorf("items = %+v", items)
+	}
+}
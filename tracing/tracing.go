@@ -0,0 +1,93 @@
+// Package tracing exports OpenTelemetry spans for runs, FUB requests, MLS lookups and emails
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of Config.Exporter
+const (
+	EXPORTER_NONE   = ""       // Tracing disabled
+	EXPORTER_OTLP   = "otlp"   // OTLP over HTTP to a collector
+	EXPORTER_STDOUT = "stdout" // JSON to standard output or Config.File, for local debugging
+)
+
+const SERVICE_NAME = "for-sale-report"
+
+// Config represents tracing-related configuration
+type Config struct {
+	Exporter string `toml:"exporter"` // EXPORTER_OTLP, EXPORTER_STDOUT or empty to disable
+	Endpoint string `toml:"endpoint"` // OTLP/HTTP URL such as http://localhost:4318, the OTEL_EXPORTER_OTLP_* variables when empty
+	File     string `toml:"file"`     // File the stdout exporter appends to, standard output when empty
+}
+
+// Setup installs a global tracer provider exporting spans as configured.
+// shutdown flushes any spans not yet exported and must be called before exiting.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+
+	switch config.Exporter {
+	case EXPORTER_NONE:
+		return func(context.Context) error { return nil }, nil
+	case EXPORTER_OTLP:
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case EXPORTER_STDOUT:
+		var out io.Writer = os.Stdout
+		if config.File != "" {
+			file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open trace file: %w", err)
+			}
+			out = file
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", config.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", SERVICE_NAME))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// End records err, if not nil, on span and ends it; deferred with a pointer to a named error
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
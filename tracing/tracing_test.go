@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestStdoutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Config{Exporter: EXPORTER_STDOUT, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() (err error) {
+		_, span := otel.Tracer("test").Start(context.Background(), "mls.Lookup")
+		defer End(span, &err)
+		return errors.New("No results found")
+	}()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Name":"mls.Lookup"`, `"Description":"No results found"`, `"Value":"for-sale-report"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("trace file missing %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+	if _, err := Setup(context.Background(), Config{Exporter: EXPORTER_STDOUT, File: filepath.Join(t.TempDir(), "missing", "traces.json")}); err == nil {
+		t.Error("unwritable trace file accepted")
+	}
+}